@@ -0,0 +1,206 @@
+package imretro
+
+import (
+	"encoding/binary"
+	"errors"
+	"image"
+	"image/color"
+	"io"
+)
+
+// AnimationSignature is the "magic string" used for identifying an animation
+// file.
+const AnimationSignature = "IMRANIM"
+
+var (
+	// ErrNoFrames is returned when encoding an animation without frames.
+	ErrNoFrames = errors.New("Animation has no frames")
+	// ErrMismatchedFrames is returned when encoding an animation that does not
+	// have a delay for each frame.
+	ErrMismatchedFrames = errors.New("Mismatched frame and delay counts")
+	// ErrAnimationRange is returned when encoding an animation with too many
+	// frames, or a delay or loop count that cannot be stored. It is also
+	// returned when encoding a palette cycle with too many ranges.
+	ErrAnimationRange = errors.New("Animation value out of range")
+)
+
+// Animation is a sequence of imretro frames, like image/gif's GIF type.
+type Animation struct {
+	// Image is the frames of the animation. Frames are drawn at the top-left
+	// corner of the animation.
+	Image []Image
+	// Delay is the delay time of each frame, in 100ths of a second.
+	Delay []int
+	// LoopCount controls the number of times an animation will be restarted
+	// during display. A LoopCount of 0 means to loop forever, a LoopCount of
+	// -1 means to show each frame only once, and otherwise the animation is
+	// looped LoopCount+1 times.
+	LoopCount int
+	// Config is the size of the animation, and its shared palette. If
+	// Config.ColorModel is a ColorModel or a color.Palette, frames that have
+	// the same palette are written without their own palette. Other color
+	// models cannot be encoded. If the width and height are
+	// zero, the size of the first frame is used.
+	Config image.Config
+	// PaletteFormat is the layout of the shared palette. See the
+	// EncodeOptions type for details.
+	PaletteFormat ModeFlag
+}
+
+// EncodeAll writes the frames of the animation to w. The animation's header
+// and shared palette use the same layout as an imretro image, and each frame
+// is written as an imretro image after its delay.
+func EncodeAll(w io.Writer, a *Animation) error {
+	if len(a.Image) == 0 {
+		return ErrNoFrames
+	}
+	if len(a.Image) != len(a.Delay) {
+		return ErrMismatchedFrames
+	}
+	if len(a.Image) > 0xFFFF || a.LoopCount < -1 || a.LoopCount >= 0xFFFF {
+		return ErrAnimationRange
+	}
+
+	shared := paletteModel(a.Config.ColorModel)
+	if shared == nil && a.Config.ColorModel != nil {
+		return ErrUnknownModel
+	}
+	var mode, paletteFormat ModeFlag
+	var paletteChannels int
+	if shared != nil {
+		if len(shared) > 1<<8 {
+			return ErrUnknownModel
+		}
+		paletteFormat = a.PaletteFormat
+		if paletteFormat == 0 {
+			paletteFormat = DefaultPaletteFormat
+		}
+		var ok bool
+		paletteChannels, ok = channelCount(paletteFormat & (0b11 << colorChannelIndex))
+		if paletteFormat&^paletteFormatBits != 0 || paletteFormat&WithPalette == 0 || !ok {
+			return UnsupportedPaletteFormatError(paletteFormat)
+		}
+		mode = shared.PixelMode() | paletteFormat
+	}
+
+	width, height := a.Config.Width, a.Config.Height
+	if width == 0 && height == 0 {
+		size := a.Image[0].Bounds().Size()
+		width, height = size.X, size.Y
+	}
+
+	if _, err := w.Write([]byte(AnimationSignature)); err != nil {
+		return err
+	}
+	if _, err := w.Write([]byte{mode}); err != nil {
+		return err
+	}
+	if err := encodeDimensions(w, width, height); err != nil {
+		return err
+	}
+	if shared != nil {
+		accurateColors := paletteFormat&EightBitColors != 0
+		if err := writePalette(w, shared, shared.PixelMode(), paletteChannels, accurateColors); err != nil {
+			return err
+		}
+	}
+
+	counts := make([]byte, 4)
+	binary.BigEndian.PutUint16(counts, uint16(a.LoopCount+1))
+	binary.BigEndian.PutUint16(counts[2:], uint16(len(a.Image)))
+	if _, err := w.Write(counts); err != nil {
+		return err
+	}
+
+	for i, frame := range a.Image {
+		delay := a.Delay[i]
+		if delay < 0 || delay > 0xFFFF {
+			return ErrAnimationRange
+		}
+		if _, err := w.Write([]byte{byte(delay >> 8), byte(delay)}); err != nil {
+			return err
+		}
+		options := EncodeOptions{PaletteFormat: framePaletteFormat(frame)}
+		if shared != nil && samePalette(frame.Palette(), color.Palette(shared.padded(shared.PixelMode()))) {
+			// NOTE The model is not set, so that the frame's indices are
+			// written without changes.
+			options = EncodeOptions{NoPalette: true}
+		}
+		if err := EncodeWithOptions(w, frame, options); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// FramePaletteFormat returns the palette format that a frame was decoded
+// with, so that frames that were written without a palette get their own
+// palette when the shared palette is different.
+func framePaletteFormat(m Image) ModeFlag {
+	if i, ok := m.(imretroImage); ok && !i.noPalette {
+		return i.paletteFormat
+	}
+	return DefaultPaletteFormat
+}
+
+// DecodeAll decodes an animation written by EncodeAll. The offsets of
+// DecodeErrors are from the start of the animation.
+//
+// Frames without their own palette use the shared palette. If there is no
+// shared palette, the custom models are used for those frames, like Decode.
+func DecodeAll(r io.Reader, customModels CustomModel) (*Animation, error) {
+	reader := &offsetReader{Reader: r}
+	buff := make([]byte, len(AnimationSignature)+1)
+	modeOffset := int64(len(AnimationSignature))
+	mode, err := checkSignature(reader, buff, AnimationSignature)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkMode(mode); err != nil {
+		return nil, newDecodeError(PhaseMode, modeOffset, err)
+	}
+	width, height, err := decodeDimensions(reader)
+	if err != nil {
+		return nil, newDecodeError(PhaseDimensions, reader.offset, err)
+	}
+
+	a := &Animation{Config: image.Config{Width: width, Height: height}}
+	if mode&WithPalette != 0 {
+		pixelMode := mode & (0b11 << pixelBitsIndex)
+		size, ok := modelSize(pixelMode)
+		if !ok {
+			return nil, newDecodeError(PhaseMode, modeOffset, MissingModelError(pixelMode))
+		}
+		model, err := decodeModel(reader, size, mode&EightBitColors != 0, mode&(0b11<<colorChannelIndex))
+		if err != nil {
+			return nil, newDecodeError(PhasePalette, reader.offset, err)
+		}
+		a.Config.ColorModel = model
+		a.PaletteFormat = mode & paletteFormatBits
+		customModels = model.(ColorModel)
+	}
+
+	counts := make([]byte, 4)
+	if _, err := io.ReadFull(reader, counts); err != nil {
+		return nil, newDecodeError(PhaseData, reader.offset, err)
+	}
+	a.LoopCount = int(binary.BigEndian.Uint16(counts)) - 1
+	frameCount := int(binary.BigEndian.Uint16(counts[2:]))
+
+	a.Image = make([]Image, 0, frameCount)
+	a.Delay = make([]int, 0, frameCount)
+	delay := make([]byte, 2)
+	for i := 0; i < frameCount; i++ {
+		if _, err := io.ReadFull(reader, delay); err != nil {
+			return nil, newDecodeError(PhaseData, reader.offset, err)
+		}
+		frameStart := reader.offset
+		frame, err := Decode(reader, customModels)
+		if err != nil {
+			return nil, shiftOffset(err, frameStart)
+		}
+		a.Image = append(a.Image, frame)
+		a.Delay = append(a.Delay, int(binary.BigEndian.Uint16(delay)))
+	}
+	return a, nil
+}
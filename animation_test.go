@@ -0,0 +1,220 @@
+package imretro
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/color"
+	"io"
+	"testing"
+)
+
+// TestEncodeAllSharedPalette tests that frames with the shared palette are
+// written without their own palette, and that the animation can be decoded.
+func TestEncodeAllSharedPalette(t *testing.T) {
+	shared := NewOneBitColorModel(color.Gray{0}, color.Gray{0xFF})
+	other := NewOneBitColorModel(color.RGBA{0xFF, 0, 0, 0xFF}, color.RGBA{0, 0, 0xFF, 0xFF})
+	frames := []Image{
+		New(image.Rect(0, 0, 2, 1), shared),
+		New(image.Rect(0, 0, 2, 1), other),
+	}
+	frames[0].(MutableImage).SetColorIndex(1, 0, 1)
+	frames[1].(MutableImage).SetColorIndex(0, 0, 1)
+	a := &Animation{
+		Image:         frames,
+		Delay:         []int{10, 300},
+		LoopCount:     2,
+		Config:        image.Config{ColorModel: shared},
+		PaletteFormat: WithPalette | Grayscale | EightBitColors,
+	}
+
+	var b bytes.Buffer
+	if err := EncodeAll(&b, a); err != nil {
+		t.Fatalf(`err = %v, want nil`, err)
+	}
+	wantHeader := []byte{
+		'I', 'M', 'R', 'A', 'N', 'I', 'M',
+		OneBit | WithPalette | Grayscale | EightBitColors,
+		0x00, 0x20, 0x01,
+		0x00, 0xFF,
+		0, 3, 0, 2,
+		0, 10,
+		'I', 'M', 'R', 'E', 'T', 'R', 'O',
+		OneBit,
+		0x00, 0x20, 0x01,
+		0b0100_0000,
+		0x01, 0x2C,
+	}
+	if actual := b.Bytes()[:len(wantHeader)]; !bytes.Equal(actual, wantHeader) {
+		t.Fatalf(`bytes = %v, want %v`, actual, wantHeader)
+	}
+
+	decoded, err := DecodeAll(&b, nil)
+	if err != nil {
+		t.Fatalf(`err = %v, want nil`, err)
+	}
+	if decoded.LoopCount != 2 {
+		t.Errorf(`LoopCount = %d, want 2`, decoded.LoopCount)
+	}
+	if decoded.Config.Width != 2 || decoded.Config.Height != 1 {
+		t.Errorf(`size = %dx%d, want 2x1`, decoded.Config.Width, decoded.Config.Height)
+	}
+	if decoded.PaletteFormat != a.PaletteFormat {
+		t.Errorf(`PaletteFormat = %08b, want %08b`, decoded.PaletteFormat, a.PaletteFormat)
+	}
+	if len(decoded.Image) != 2 {
+		t.Fatalf(`len(Image) = %d, want 2`, len(decoded.Image))
+	}
+	for i, frame := range frames {
+		if actual, want := decoded.Delay[i], a.Delay[i]; actual != want {
+			t.Errorf(`Delay[%d] = %d, want %d`, i, actual, want)
+		}
+		for x := 0; x < 2; x++ {
+			CompareColors(t, decoded.Image[i].At(x, 0), frame.At(x, 0))
+		}
+	}
+}
+
+// TestEncodeAllSharedColorPalette tests that the indices of frames that use a
+// shared palette with colors are written without changes.
+func TestEncodeAllSharedColorPalette(t *testing.T) {
+	shared := ColorModel{
+		color.RGBA{0xFF, 0, 0, 0xFF},
+		color.RGBA{0, 0xFF, 0, 0xFF},
+		color.RGBA{0, 0, 0xFF, 0xFF},
+		color.RGBA{0xFF, 0xFF, 0, 0xFF},
+	}
+	frame := New(image.Rect(0, 0, 4, 1), shared)
+	for x := 0; x < 4; x++ {
+		frame.SetColorIndex(x, 0, uint8(x))
+	}
+	a := &Animation{Image: []Image{frame}, Delay: []int{0}, Config: image.Config{ColorModel: shared}}
+
+	var b bytes.Buffer
+	if err := EncodeAll(&b, a); err != nil {
+		t.Fatalf(`err = %v, want nil`, err)
+	}
+	decoded, err := DecodeAll(&b, nil)
+	if err != nil {
+		t.Fatalf(`err = %v, want nil`, err)
+	}
+	for x := 0; x < 4; x++ {
+		if actual := decoded.Image[0].ColorIndexAt(x, 0); actual != uint8(x) {
+			t.Errorf(`index at (%d, 0) = %d, want %d`, x, actual, x)
+		}
+		CompareColors(t, decoded.Image[0].At(x, 0), shared[x])
+	}
+}
+
+// TestEncodeAllPaletteConfig tests that a color.Palette is used as the shared
+// palette, like a ColorModel, and that other color models are rejected.
+func TestEncodeAllPaletteConfig(t *testing.T) {
+	shared := ColorModel{black, color.RGBA{0xFF, 0, 0, 0xFF}}
+	frame := New(image.Rect(0, 0, 2, 1), shared)
+	frame.SetColorIndex(1, 0, 1)
+	a := &Animation{Image: []Image{frame}, Delay: []int{0}, Config: image.Config{ColorModel: shared}}
+	var want bytes.Buffer
+	if err := EncodeAll(&want, a); err != nil {
+		t.Fatalf(`err = %v, want nil`, err)
+	}
+
+	a.Config.ColorModel = color.Palette(shared)
+	var b bytes.Buffer
+	if err := EncodeAll(&b, a); err != nil {
+		t.Fatalf(`err = %v, want nil`, err)
+	}
+	if actual := b.Bytes(); !bytes.Equal(actual, want.Bytes()) {
+		t.Errorf(`bytes = %v, want %v`, actual, want.Bytes())
+	}
+
+	a.Config.ColorModel = color.RGBAModel
+	b.Reset()
+	if err := EncodeAll(&b, a); err != ErrUnknownModel {
+		t.Errorf(`err = %v, want %v`, err, ErrUnknownModel)
+	}
+}
+
+// TestEncodeAllRoundTrip tests that an animation without a shared palette is
+// encoded to the same bytes after it is decoded.
+func TestEncodeAllRoundTrip(t *testing.T) {
+	frame := New(image.Rect(0, 0, 3, 3), Default2BitColorModel)
+	frame.SetColorIndex(1, 1, 2)
+	a := &Animation{Image: []Image{frame, frame}, Delay: []int{5, 5}, LoopCount: -1}
+
+	var b bytes.Buffer
+	if err := EncodeAll(&b, a); err != nil {
+		t.Fatalf(`err = %v, want nil`, err)
+	}
+	want := append([]byte(nil), b.Bytes()...)
+	decoded, err := DecodeAll(&b, nil)
+	if err != nil {
+		t.Fatalf(`err = %v, want nil`, err)
+	}
+	if decoded.Config.ColorModel != nil {
+		t.Errorf(`ColorModel = %v, want nil`, decoded.Config.ColorModel)
+	}
+	if decoded.LoopCount != -1 {
+		t.Errorf(`LoopCount = %d, want -1`, decoded.LoopCount)
+	}
+	if err := EncodeAll(&b, decoded); err != nil {
+		t.Fatalf(`err = %v, want nil`, err)
+	}
+	if actual := b.Bytes(); !bytes.Equal(actual, want) {
+		t.Fatalf(`bytes = %v, want %v`, actual, want)
+	}
+}
+
+// TestEncodeAllErrors tests that invalid animations are not encoded.
+func TestEncodeAllErrors(t *testing.T) {
+	frame := New(image.Rect(0, 0, 1, 1), Default1BitColorModel)
+	tests := []struct {
+		a    *Animation
+		want error
+	}{
+		{&Animation{}, ErrNoFrames},
+		{&Animation{Image: []Image{frame}}, ErrMismatchedFrames},
+		{&Animation{Image: []Image{frame}, Delay: []int{-1}}, ErrAnimationRange},
+		{&Animation{Image: []Image{frame}, Delay: []int{0}, LoopCount: 0xFFFF}, ErrAnimationRange},
+	}
+	for i, tt := range tests {
+		var b bytes.Buffer
+		if err := EncodeAll(&b, tt.a); err != tt.want {
+			t.Errorf(`test %d: err = %v, want %v`, i, err, tt.want)
+		}
+	}
+}
+
+// TestDecodeAllBadSignature tests that an image is not decoded as an
+// animation.
+func TestDecodeAllBadSignature(t *testing.T) {
+	r := bytes.NewBufferString("IMRETRO\x00\x00\x10\x01\x00")
+	if _, err := DecodeAll(r, nil); !errors.Is(err, ErrBadSignature) {
+		t.Fatalf(`err = %v, want %v`, err, ErrBadSignature)
+	}
+}
+
+// TestDecodeAllTruncatedFrame tests that the offset of a truncated frame's
+// error is from the start of the animation.
+func TestDecodeAllTruncatedFrame(t *testing.T) {
+	frame := New(image.Rect(0, 0, 3, 3), Default2BitColorModel)
+	a := &Animation{Image: []Image{frame, frame}, Delay: []int{5, 5}}
+	var b bytes.Buffer
+	if err := EncodeAll(&b, a); err != nil {
+		t.Fatalf(`err = %v, want nil`, err)
+	}
+	data := b.Bytes()[:b.Len()-1]
+
+	_, err := DecodeAll(bytes.NewBuffer(data), nil)
+	if !errors.Is(err, ErrTruncatedPixels) {
+		t.Fatalf(`err = %v, want %v`, err, ErrTruncatedPixels)
+	}
+	var decodeErr *DecodeError
+	if !errors.As(err, &decodeErr) || decodeErr.Phase != PhasePixels || decodeErr.Offset != int64(len(data)) {
+		t.Errorf(`err = %#v, want pixels error at byte %d`, err, len(data))
+	}
+
+	_, err = DecodeAll(bytes.NewBuffer(data[:len(AnimationSignature)+6]), nil)
+	if !errors.As(err, &decodeErr) || decodeErr.Phase != PhaseData || !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Errorf(`err = %v, want truncated data error`, err)
+	}
+}
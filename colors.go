@@ -2,6 +2,8 @@ package imretro
 
 import (
 	"image/color"
+
+	"github.com/imretro/go/internal/util"
 )
 
 var (
@@ -27,6 +29,30 @@ var (
 // grayscale, RGB, or RGBA.
 type colorBytes []byte
 
+// NewColorBytes converts a color to grayscale, RGB, or RGBA bytes depending on
+// the number of channels.
+func newColorBytes(c color.Color, channelCount int) colorBytes {
+	if channelCount == 1 {
+		return colorBytes{color.GrayModel.Convert(c).(color.Gray).Y}
+	}
+	r, g, b, a := util.ColorAsBytes(c)
+	return colorBytes{r, g, b, a}[:channelCount]
+}
+
+// ChannelCount returns the number of channels for the color channel flag. Ok is
+// false if the flag is not Grayscale, RGB, or RGBA.
+func channelCount(colorChannels ModeFlag) (count int, ok bool) {
+	switch colorChannels {
+	case Grayscale:
+		return 1, true
+	case RGB:
+		return 3, true
+	case RGBA:
+		return 4, true
+	}
+	return 0, false
+}
+
 func (c colorBytes) RGBA() (r, g, b, a uint32) {
 	return c.AsColor().RGBA()
 }
@@ -0,0 +1,113 @@
+package imretro
+
+import (
+	"errors"
+	"io"
+)
+
+// CycleSignature is the "magic string" used for identifying palette cycles.
+const CycleSignature = "IMRCYCL"
+
+// ErrInvalidCycleRange is the cause of a DecodeError when a decoded cycle range
+// ends before it starts, or has an unknown direction.
+var ErrInvalidCycleRange = errors.New("Invalid palette cycle range")
+
+// CycleDirection is the direction that the colors of a CycleRange move.
+type CycleDirection = byte
+
+// Directions for palette cycling.
+const (
+	// CycleForward moves each color to the next index of the range, and the
+	// last color of the range to the first index.
+	CycleForward CycleDirection = iota
+	// CycleBackward moves each color to the previous index of the range, and
+	// the first color of the range to the last index.
+	CycleBackward
+)
+
+// CycleRange is a range of palette indices whose colors are rotated.
+type CycleRange struct {
+	// Low and High are the first and last indices of the range.
+	Low, High uint8
+	// Rate is the number of frames between each step of the rotation. A range
+	// with a Rate of 0 is not rotated.
+	Rate uint16
+	// Direction is the direction that the colors are rotated.
+	Direction CycleDirection
+}
+
+// PaletteCycle describes how the colors of a palette are rotated to animate an
+// image without changing its pixels.
+type PaletteCycle []CycleRange
+
+// Model returns the model at the frame of the cycle. The ranges are rotated
+// in order, and the parts of ranges that are outside of the model are ignored.
+func (c PaletteCycle) Model(model ColorModel, frame int) ColorModel {
+	cycled := make(ColorModel, len(model))
+	copy(cycled, model)
+	for _, r := range c {
+		low, high := int(r.Low), int(r.High)
+		if high >= len(model) {
+			high = len(model) - 1
+		}
+		length := high - low + 1
+		if r.Rate == 0 || length <= 1 {
+			continue
+		}
+		shift := modulo(frame/int(r.Rate), length)
+		if r.Direction == CycleBackward {
+			shift = length - shift
+		}
+		colors := make(ColorModel, length)
+		copy(colors, cycled[low:high+1])
+		for i, c := range colors {
+			cycled[low+(i+shift)%length] = c
+		}
+	}
+	return cycled
+}
+
+// Frame returns the image at the frame of the cycle. The returned image shares
+// its pixels with m.
+func (c PaletteCycle) Frame(m Image, frame int) Image {
+	return withModel(m, c.Model(ColorModel(m.Palette()), frame))
+}
+
+// EncodePaletteCycle writes the palette cycle to w. It can be written after
+// an image in the same stream, so that the cycle is stored alongside the
+// image.
+func EncodePaletteCycle(w io.Writer, c PaletteCycle) error {
+	if len(c) > 0xFF {
+		return ErrAnimationRange
+	}
+	buffer := make([]byte, 0, len(CycleSignature)+1+len(c)*5)
+	buffer = append(buffer, CycleSignature...)
+	buffer = append(buffer, byte(len(c)))
+	for _, r := range c {
+		buffer = append(buffer, r.Low, r.High, byte(r.Rate>>8), byte(r.Rate), r.Direction)
+	}
+	_, err := w.Write(buffer)
+	return err
+}
+
+// DecodePaletteCycle reads a palette cycle written by EncodePaletteCycle.
+func DecodePaletteCycle(r io.Reader) (PaletteCycle, error) {
+	buff := make([]byte, len(CycleSignature)+1)
+	count, err := checkSignature(r, buff, CycleSignature)
+	if err != nil {
+		return nil, err
+	}
+	ranges := make([]byte, int(count)*5)
+	if n, err := io.ReadFull(r, ranges); err != nil {
+		return nil, newDecodeError(PhaseData, int64(len(buff)+n), err)
+	}
+	c := make(PaletteCycle, count)
+	for i := range c {
+		b := ranges[i*5:]
+		if b[0] > b[1] || b[4] > CycleBackward {
+			return nil, newDecodeError(PhaseData, int64(len(buff)+i*5), ErrInvalidCycleRange)
+		}
+		c[i] = CycleRange{b[0], b[1], uint16(b[2])<<8 | uint16(b[3]), b[4]}
+	}
+	return c, nil
+}
@@ -0,0 +1,107 @@
+package imretro
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/color"
+	"testing"
+)
+
+// TestPaletteCycleModel tests that ranges of the model are rotated.
+func TestPaletteCycleModel(t *testing.T) {
+	model := ColorModel{
+		color.Gray{0}, color.Gray{1}, color.Gray{2}, color.Gray{3},
+	}
+	tests := []struct {
+		cycle PaletteCycle
+		frame int
+		want  []uint8
+	}{
+		{PaletteCycle{{1, 3, 1, CycleForward}}, 0, []uint8{0, 1, 2, 3}},
+		{PaletteCycle{{1, 3, 1, CycleForward}}, 1, []uint8{0, 3, 1, 2}},
+		{PaletteCycle{{1, 3, 1, CycleForward}}, 4, []uint8{0, 3, 1, 2}},
+		{PaletteCycle{{1, 3, 1, CycleBackward}}, 1, []uint8{0, 2, 3, 1}},
+		{PaletteCycle{{0, 3, 2, CycleForward}}, 5, []uint8{2, 3, 0, 1}},
+		{PaletteCycle{{0, 3, 0, CycleForward}}, 5, []uint8{0, 1, 2, 3}},
+		{PaletteCycle{{2, 9, 1, CycleForward}}, 1, []uint8{0, 1, 3, 2}},
+		{PaletteCycle{{0, 3, 1, CycleForward}}, -1, []uint8{1, 2, 3, 0}},
+	}
+	for i, tt := range tests {
+		actual := tt.cycle.Model(model, tt.frame)
+		for j, want := range tt.want {
+			if actual[j] != model[want] {
+				t.Errorf(`test %d: color %d = %v, want %v`, i, j, actual[j], model[want])
+			}
+		}
+	}
+	if model[1] != (color.Gray{1}) {
+		t.Fatalf(`original model was changed`)
+	}
+}
+
+// TestPaletteCycleFrame tests that the frame of an image shares its pixels.
+func TestPaletteCycleFrame(t *testing.T) {
+	m := New(image.Rect(0, 0, 2, 1), Default1BitColorModel)
+	m.SetColorIndex(1, 0, 1)
+	cycle := PaletteCycle{{0, 1, 1, CycleForward}}
+
+	frame := cycle.Frame(m, 1)
+	CompareColors(t, frame.At(0, 0), Default1BitColorModel[1])
+	CompareColors(t, frame.At(1, 0), Default1BitColorModel[0])
+	m.SetColorIndex(0, 0, 1)
+	CompareColors(t, frame.At(0, 0), Default1BitColorModel[0])
+	CompareColors(t, m.At(0, 0), Default1BitColorModel[1])
+}
+
+// TestPaletteCycleRoundTrip tests that palette cycles can be written after an
+// image.
+func TestPaletteCycleRoundTrip(t *testing.T) {
+	cycle := PaletteCycle{{1, 3, 300, CycleBackward}, {4, 8, 2, CycleForward}}
+	var b bytes.Buffer
+	if err := Encode(&b, New(image.Rect(0, 0, 1, 1), Default1BitColorModel), OneBit); err != nil {
+		t.Fatalf(`err = %v, want nil`, err)
+	}
+	if err := EncodePaletteCycle(&b, cycle); err != nil {
+		t.Fatalf(`err = %v, want nil`, err)
+	}
+	if _, err := Decode(&b, nil); err != nil {
+		t.Fatalf(`err = %v, want nil`, err)
+	}
+	want := []byte{
+		'I', 'M', 'R', 'C', 'Y', 'C', 'L',
+		2,
+		1, 3, 0x01, 0x2C, CycleBackward,
+		4, 8, 0, 2, CycleForward,
+	}
+	if actual := b.Bytes(); !bytes.Equal(actual, want) {
+		t.Fatalf(`bytes = %v, want %v`, actual, want)
+	}
+
+	decoded, err := DecodePaletteCycle(&b)
+	if err != nil {
+		t.Fatalf(`err = %v, want nil`, err)
+	}
+	if len(decoded) != len(cycle) {
+		t.Fatalf(`len = %d, want %d`, len(decoded), len(cycle))
+	}
+	for i, want := range cycle {
+		if decoded[i] != want {
+			t.Errorf(`range %d = %v, want %v`, i, decoded[i], want)
+		}
+	}
+}
+
+// TestDecodePaletteCycleInvalidRange tests that a range that ends before it
+// starts is not decoded.
+func TestDecodePaletteCycleInvalidRange(t *testing.T) {
+	r := bytes.NewBufferString("IMRCYCL\x01\x03\x01\x00\x01\x00")
+	_, err := DecodePaletteCycle(r)
+	if !errors.Is(err, ErrInvalidCycleRange) {
+		t.Fatalf(`err = %v, want %v`, err, ErrInvalidCycleRange)
+	}
+	var decodeErr *DecodeError
+	if !errors.As(err, &decodeErr) || decodeErr.Phase != PhaseData || decodeErr.Offset != 8 {
+		t.Errorf(`err = %#v, want data error at byte 8`, err)
+	}
+}
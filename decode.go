@@ -1,9 +1,12 @@
 package imretro
 
 import (
+	"errors"
+	"fmt"
 	"image"
 	"image/color"
 	"io"
+	"math/bits"
 
 	"github.com/spenserblack/go-bitio"
 	"github.com/spenserblack/go-byteutils"
@@ -14,9 +17,56 @@ import (
 // ImretroSignature is the "magic string" used for identifying an imretro file.
 const ImretroSignature = "IMRETRO"
 
+// DecodePhase is the part of an image that was being decoded when a
+// DecodeError occurred.
+type DecodePhase int
+
+// Phases of decoding an image.
+const (
+	PhaseSignature DecodePhase = iota
+	PhaseMode
+	PhaseDimensions
+	PhasePalette
+	PhasePixels
+	PhaseTrailingData
+	// PhaseData is the data of the tilemap, animation, and palette cycle
+	// formats that is not part of an image.
+	PhaseData
+)
+
+var (
+	// ErrBadSignature is the cause of a DecodeError when the reader does not
+	// start with the signature.
+	ErrBadSignature = errors.New("Unexpected signature byte")
+	// ErrInvalidMode is matched by the errors for mode bytes that cannot be
+	// decoded, such as InvalidModeError.
+	ErrInvalidMode = errors.New("Invalid mode byte")
+	// ErrTruncatedPixels is the cause of a DecodeError when the reader ends
+	// before all of the pixels have been read.
+	ErrTruncatedPixels = errors.New("Not enough pixels for the dimensions")
+	// ErrUnusedPaletteFlags is the cause of a Problem when the mode byte of
+	// an image without an in-file palette has palette format flags.
+	ErrUnusedPaletteFlags = errors.New("Palette format flags are set without a palette")
+	// ErrPaddingBits is the cause of a Problem when the bits after the last
+	// pixel are not 0.
+	ErrPaddingBits = errors.New("Padding bits after the last pixel are not zero")
+	// ErrTrailingData is the cause of a Problem when there is data after the
+	// pixels.
+	ErrTrailingData = errors.New("Unexpected data after the pixels")
+)
+
 // DecodeError is an error signifying that something unexpected happened when
-// decoding the imretro reader.
-type DecodeError string
+// decoding the imretro reader. If the reader ends before the pixels, the
+// cause is io.ErrUnexpectedEOF.
+type DecodeError struct {
+	// Phase is the part of the image that was being decoded.
+	Phase DecodePhase
+	// Offset is the offset of the byte where the problem was found, from the
+	// start of the image.
+	Offset int64
+	// Err is the cause of the error.
+	Err error
+}
 
 // Decode decodes an image in the imretro format.
 //
@@ -27,7 +77,125 @@ type DecodeError string
 // details. If the decoded image contains an in-image palette, the model will be
 // generated from that instead of the custom value passed or the default models.
 func Decode(r io.Reader, customModels CustomModel) (Image, error) {
-	config, err := DecodeConfig(r, customModels)
+	return DecodeWithOptions(r, DecodeOptions{CustomModels: customModels})
+}
+
+// DecodeOptions are the options used by DecodeWithOptions and
+// DecodeConfigWithOptions.
+type DecodeOptions struct {
+	// CustomModels are used instead of the default color models for images
+	// without an in-file palette. See Decode for details.
+	CustomModels CustomModel
+	// MaxWidth and MaxHeight are the largest dimensions that will be decoded.
+	// Zero means that a dimension is not limited.
+	MaxWidth, MaxHeight int
+	// MaxArea is the largest number of pixels that will be decoded. Zero
+	// means that the area is not limited.
+	MaxArea int
+	// MaxAlloc is the largest number of bytes that will be allocated for the
+	// pixels and the in-file palette. Zero means that the allocation is not
+	// limited.
+	MaxAlloc int
+	// Lenient decodes images whose pixels end early. The missing pixels are
+	// set to FillIndex, and the image is returned with a PartialImageError.
+	Lenient bool
+	// FillIndex is the palette index of the missing pixels when Lenient is
+	// set. Only the bits that the pixel mode uses are kept.
+	FillIndex uint8
+	// Strict returns an error for the problems that Validate reports, even if
+	// the image could be decoded. Checking for trailing data reads a byte after
+	// the pixels.
+	Strict bool
+	// Problems collects the problems that do not stop the image from being
+	// decoded, when it is set by Validate.
+	problems *[]Problem
+}
+
+// Problem is an issue with an image that is reported by Validate.
+type Problem DecodeError
+
+// String describes the problem, like DecodeError.Error.
+func (p Problem) String() string {
+	return (*DecodeError)(&p).Error()
+}
+
+// Validate decodes an image from r and reports its problems, including those
+// that the decoder ignores: reserved mode bits, palette format flags in an
+// image without a palette, padding bits that are not 0, and data after the
+// pixels. If the image cannot be decoded, the error is the last problem.
+func Validate(r io.Reader) []Problem {
+	problems := []Problem{}
+	_, err := DecodeWithOptions(r, DecodeOptions{problems: &problems})
+	if err != nil {
+		var decodeErr *DecodeError
+		if !errors.As(err, &decodeErr) {
+			decodeErr = &DecodeError{Err: err}
+		}
+		problems = append(problems, Problem(*decodeErr))
+	}
+	return problems
+}
+
+// Report records a problem that the decoder can ignore. It returns the
+// problem as an error in strict mode.
+func (o DecodeOptions) report(p Problem) error {
+	if o.Strict {
+		return (*DecodeError)(&p)
+	}
+	if o.problems != nil {
+		*o.problems = append(*o.problems, p)
+	}
+	return nil
+}
+
+// Validating checks if the problems that the decoder can ignore should be
+// found.
+func (o DecodeOptions) validating() bool {
+	return o.Strict || o.problems != nil
+}
+
+// PartialImageError is returned with the image when the pixels of an image
+// decoded with the Lenient option end early. It matches ErrTruncatedPixels.
+type PartialImageError struct {
+	// Offset is the offset of the end of the image's data.
+	Offset int64
+	// Missing is the number of bytes of pixels that were missing.
+	Missing int
+}
+
+// Error reports the number of missing bytes.
+func (e PartialImageError) Error() string {
+	return fmt.Sprintf("Image is missing %d bytes of pixels after byte %d", e.Missing, e.Offset)
+}
+
+// Unwrap returns ErrTruncatedPixels.
+func (e PartialImageError) Unwrap() error {
+	return ErrTruncatedPixels
+}
+
+// LimitError is returned when an image is larger than a limit of the
+// DecodeOptions.
+type LimitError struct {
+	// Limit is the name of the limit that was exceeded: "width", "height",
+	// "area", or "allocation".
+	Limit string
+	// Max is the value of the limit.
+	Max int
+	// Value is the value that exceeded the limit.
+	Value int
+}
+
+// Error reports the limit that was exceeded.
+func (e LimitError) Error() string {
+	return fmt.Sprintf("Image %s of %d exceeds the limit of %d", e.Limit, e.Value, e.Max)
+}
+
+// DecodeWithOptions decodes an image in the imretro format. The image is not
+// decoded if it exceeds the limits of the options, which are checked before
+// the palette and pixels are read.
+func DecodeWithOptions(r io.Reader, o DecodeOptions) (Image, error) {
+	reader := &offsetReader{Reader: r}
+	config, mode, err := decodeConfig(reader, o)
 	if err != nil {
 		return nil, err
 	}
@@ -38,11 +206,121 @@ func Decode(r io.Reader, customModels CustomModel) (Image, error) {
 		bytesNeeded++
 	}
 	pixels := make([]byte, bytesNeeded)
-	if _, err := io.ReadFull(r, pixels); err != nil {
+	n, err := io.ReadFull(reader, pixels)
+	if err != nil && !(o.Lenient && (err == io.EOF || err == io.ErrUnexpectedEOF)) {
+		return nil, newDecodeError(PhasePixels, reader.offset, err)
+	}
+
+	m := imretroImage{
+		config:        config,
+		pixels:        pixels,
+		paletteFormat: mode & paletteFormatBits,
+		noPalette:     mode&WithPalette == 0,
+	}
+	if n == len(pixels) {
+		if o.validating() {
+			err = checkPixelEnd(reader, o, pixels, area*(8/pixelsForByte))
+		}
+		return m, err
+	}
+	fill := fillByte(o.FillIndex, 8/pixelsForByte)
+	for i := n; i < len(pixels); i++ {
+		pixels[i] = fill
+	}
+	return m, PartialImageError{reader.offset, len(pixels) - n}
+}
+
+// CheckPixelEnd checks that the padding bits after the last pixel are 0, and
+// that there is no data after the pixels.
+func checkPixelEnd(r *offsetReader, o DecodeOptions, pixels []byte, bits int) error {
+	if padding := bits % 8; padding != 0 && pixels[len(pixels)-1]&(0xFF>>padding) != 0 {
+		if err := o.report(Problem{PhasePixels, r.offset - 1, ErrPaddingBits}); err != nil {
+			return err
+		}
+	}
+	end := r.offset
+	if n, _ := io.ReadFull(r, make([]byte, 1)); n != 0 {
+		return o.report(Problem{PhaseTrailingData, end, ErrTrailingData})
+	}
+	return nil
+}
+
+// FillByte repeats the index in each pixel of a byte.
+func fillByte(index uint8, bitsPerPixel int) byte {
+	index &= 0xFF >> (8 - bitsPerPixel)
+	var b byte
+	for i := 0; i < 8; i += bitsPerPixel {
+		b = b<<bitsPerPixel | index
+	}
+	return b
+}
+
+// Decoder decodes the pixels of an imretro image one row at a time, so that
+// large images can be decoded without holding all of their pixels.
+type Decoder struct {
+	r      *offsetReader
+	config image.Config
+	// Y is the number of rows that have been read.
+	y int
+	// BitOffset is the offset of the next row's first bit in its first
+	// byte. If it is not 0, that byte was read with the previous row and is
+	// kept as last.
+	bitOffset int
+	last      byte
+	buffer    []byte
+	row       []uint8
+}
+
+// NewDecoder reads the header and palette of an imretro image from r, and
+// returns a Decoder for its pixels. The custom models are used like Decode.
+func NewDecoder(r io.Reader, customModels CustomModel) (*Decoder, error) {
+	reader := &offsetReader{Reader: r}
+	config, _, err := decodeConfig(reader, DecodeOptions{CustomModels: customModels})
+	if err != nil {
 		return nil, err
 	}
+	return &Decoder{r: reader, config: config, row: make([]uint8, config.Width)}, nil
+}
+
+// Config returns the color model and dimensions of the image.
+func (d *Decoder) Config() image.Config {
+	return d.config
+}
+
+// NextRow reads the palette indices of the next row of pixels. The returned
+// slice is reused by the next call to NextRow. After the last row, NextRow
+// returns io.EOF, and if the pixels end before the last row, it returns a
+// DecodeError that matches ErrTruncatedPixels.
+func (d *Decoder) NextRow() ([]uint8, error) {
+	if d.y >= d.config.Height {
+		return nil, io.EOF
+	}
+	bitsPerPixel := d.config.ColorModel.(ColorModel).BitsPerPixel()
+	bits := d.bitOffset + d.config.Width*bitsPerPixel
+	size := (bits + 7) / 8
+	if cap(d.buffer) < size {
+		d.buffer = make([]byte, size)
+	}
+	buffer := d.buffer[:size]
+	unread := buffer
+	if d.bitOffset != 0 {
+		buffer[0] = d.last
+		unread = buffer[1:]
+	}
+	if _, err := io.ReadFull(d.r, unread); err != nil {
+		return nil, newDecodeError(PhasePixels, d.r.offset, err)
+	}
 
-	return imretroImage{config, pixels}, nil
+	for x := range d.row {
+		offset := d.bitOffset + x*bitsPerPixel
+		d.row[x] = indexInByte(buffer[offset/8], offset%8, bitsPerPixel)
+	}
+	d.bitOffset = bits % 8
+	if size > 0 {
+		d.last = buffer[size-1]
+	}
+	d.y++
+	return d.row, nil
 }
 
 // DecodeConfig returns the color model and dimensions of an imretro image
@@ -50,17 +328,43 @@ func Decode(r io.Reader, customModels CustomModel) (Image, error) {
 //
 // Custom color models can be used instead of the default model.
 func DecodeConfig(r io.Reader, customModels CustomModel) (image.Config, error) {
+	return DecodeConfigWithOptions(r, DecodeOptions{CustomModels: customModels})
+}
+
+// DecodeConfigWithOptions returns the color model and dimensions of an imretro
+// image without decoding the entire image. The limits of the options are
+// checked before the palette is read.
+func DecodeConfigWithOptions(r io.Reader, o DecodeOptions) (image.Config, error) {
+	config, _, err := decodeConfig(&offsetReader{Reader: r}, o)
+	return config, err
+}
+
+// DecodeConfig returns the color model and dimensions of an imretro image, and
+// the mode byte that they were decoded with.
+func decodeConfig(r *offsetReader, o DecodeOptions) (config image.Config, mode byte, err error) {
 	var buff []byte
-	var err error
-	modelMap := customModels
+	modelMap := o.CustomModels
 	if modelMap == nil {
 		modelMap = DefaultModelMap
 	}
 
 	buff = make([]byte, len(ImretroSignature)+1)
-	mode, err := checkHeader(r, buff)
+	mode, err = checkHeader(r, buff)
 	if err != nil {
-		return image.Config{}, err
+		return
+	}
+	modeOffset := int64(len(ImretroSignature))
+	if mode&reservedModeBits != 0 && o.problems != nil {
+		o.report(Problem{PhaseMode, modeOffset, InvalidModeError(mode)})
+		mode &^= reservedModeBits
+	}
+	if err = checkMode(mode); err != nil {
+		return config, mode, newDecodeError(PhaseMode, modeOffset, err)
+	}
+	if mode&WithPalette == 0 && mode&paletteFormatBits != 0 {
+		if err = o.report(Problem{PhaseMode, modeOffset, ErrUnusedPaletteFlags}); err != nil {
+			return
+		}
 	}
 
 	bitsPerPixel := mode & (0b11 << pixelBitsIndex)
@@ -68,32 +372,86 @@ func DecodeConfig(r io.Reader, customModels CustomModel) (image.Config, error) {
 
 	width, height, err := decodeDimensions(r)
 	if err != nil {
-		return image.Config{}, err
+		return config, mode, newDecodeError(PhaseDimensions, r.offset, err)
+	}
+	if err = o.checkLimits(mode, width, height); err != nil {
+		return config, mode, newDecodeError(PhaseDimensions, modeOffset+1, err)
 	}
 
 	var model color.Model
 	if !hasPalette {
 		var ok bool
 		model, ok = modelMap.ColorModel(bitsPerPixel)
-		if !ok {
-			err = MissingModelError(bitsPerPixel)
+		if !ok || !IsBitCountSupported(bitsPerPixel) {
+			return config, mode, newDecodeError(PhaseMode, modeOffset, MissingModelError(bitsPerPixel))
+		}
+		if model, err = checkModel(model, bitsPerPixel); err != nil {
+			return config, mode, newDecodeError(PhaseMode, modeOffset, err)
 		}
 	} else {
-		var modelSize int
-		switch bitsPerPixel {
-		case OneBit:
-			modelSize = 1 << 1
-		case TwoBit:
-			modelSize = 1 << 2
-		case EightBit:
-			modelSize = 1 << 8
-		default:
-			return image.Config{}, MissingModelError(bitsPerPixel)
+		modelSize, ok := modelSize(bitsPerPixel)
+		if !ok {
+			return config, mode, newDecodeError(PhaseMode, modeOffset, MissingModelError(bitsPerPixel))
 		}
 		model, err = decodeModel(r, modelSize, mode&EightBitColors != 0, mode&(0b11<<colorChannelIndex))
+		if err != nil {
+			return config, mode, newDecodeError(PhasePalette, r.offset, err)
+		}
+	}
+
+	return image.Config{ColorModel: model, Width: width, Height: height}, mode, nil
+}
+
+// CheckMode confirms that the mode byte does not use reserved bits, and that
+// the color channels of the in-file palette are valid.
+func checkMode(mode byte) error {
+	if mode&reservedModeBits != 0 {
+		return InvalidModeError(mode)
 	}
+	if _, ok := channelCount(mode & (0b11 << colorChannelIndex)); mode&WithPalette != 0 && !ok {
+		return InvalidModeError(mode)
+	}
+	return nil
+}
 
-	return image.Config{ColorModel: model, Width: width, Height: height}, err
+// CheckModel confirms that a custom model can be used for the pixel mode, and
+// pads it with transparent colors if it has fewer colors than the pixel mode
+// needs.
+func checkModel(model color.Model, mode PixelMode) (ColorModel, error) {
+	colorModel, ok := model.(ColorModel)
+	if !ok {
+		return nil, ErrUnknownModel
+	}
+	if size, _ := modelSize(mode); len(colorModel) > size {
+		return nil, ModelSizeError{mode, len(colorModel)}
+	}
+	return colorModel.padded(mode), nil
+}
+
+// CheckLimits returns a LimitError if an image with the mode and dimensions
+// would exceed the limits.
+func (o DecodeOptions) checkLimits(mode byte, width, height int) error {
+	area := width * height
+	alloc := area
+	if size, ok := modelSize(mode & (0b11 << pixelBitsIndex)); ok {
+		bitsPerPixel := bits.TrailingZeros(uint(size))
+		alloc = (area*bitsPerPixel + 7) / 8
+		if channels, ok := channelCount(mode & (0b11 << colorChannelIndex)); ok && mode&WithPalette != 0 {
+			alloc += size * channels
+		}
+	}
+	limits := []LimitError{
+		{"width", o.MaxWidth, width},
+		{"height", o.MaxHeight, height},
+		{"area", o.MaxArea, area},
+		{"allocation", o.MaxAlloc, alloc},
+	}
+	for _, limit := range limits {
+		if limit.Max > 0 && limit.Value > limit.Max {
+			return limit
+		}
+	}
+	return nil
 }
 
 // DecodeDimensions gets the dimensions from a reader.
@@ -114,17 +472,12 @@ func decodeDimensions(r io.Reader) (width, height int, err error) {
 // the length of the ColorModel.
 func decodeModel(r io.Reader, size int, accurateColors bool, colorChannels ModeFlag) (color.Model, error) {
 	model := make(ColorModel, size)
-	channelCount := -1
+	channelCount, ok := channelCount(colorChannels)
+	if !ok {
+		return nil, UnsupportedPaletteFormatError(WithPalette | colorChannels)
+	}
 	chunkSize := 1
 	bitsPerChannel := 2
-	switch colorChannels {
-	case Grayscale:
-		channelCount = 1
-	case RGB:
-		channelCount = 3
-	case RGBA:
-		channelCount = 4
-	}
 	if accurateColors {
 		chunkSize = channelCount
 		bitsPerChannel = 8
@@ -148,22 +501,100 @@ func decodeModel(r io.Reader, size int, accurateColors bool, colorChannels ModeF
 // CheckHeader confirms the reader is an imretro image by checking the "magic bytes",
 // and returns the "mode".
 func checkHeader(r io.Reader, buff []byte) (mode byte, err error) {
-	_, err = io.ReadFull(r, buff)
-	if err != nil {
-		return
-	}
+	return checkSignature(r, buff, ImretroSignature)
+}
 
-	for i, b := range buff[:len(buff)-1] {
-		if b != ImretroSignature[i] {
-			return mode, DecodeError("unexpected signature byte")
+// CheckSignature confirms that the reader starts with the signature, and
+// returns the byte after it. Buff must have room for the signature and that
+// byte.
+func checkSignature(r io.Reader, buff []byte, signature string) (mode byte, err error) {
+	n, err := io.ReadFull(r, buff)
+	for i, b := range buff[:n] {
+		if i < len(signature) && b != signature[i] {
+			return mode, newDecodeError(PhaseSignature, int64(i), ErrBadSignature)
 		}
 	}
+	if err != nil {
+		phase := PhaseSignature
+		if n == len(signature) {
+			phase = PhaseMode
+		}
+		return mode, newDecodeError(phase, int64(n), err)
+	}
 	return buff[len(buff)-1], nil
 }
 
-// Error reports that the format could not be decoded as imretro.
-func (e DecodeError) Error() string {
-	return string(e)
+// String returns the name of the phase.
+func (p DecodePhase) String() string {
+	switch p {
+	case PhaseSignature:
+		return "signature"
+	case PhaseMode:
+		return "mode"
+	case PhaseDimensions:
+		return "dimensions"
+	case PhasePalette:
+		return "palette"
+	case PhasePixels:
+		return "pixels"
+	case PhaseTrailingData:
+		return "trailing data"
+	case PhaseData:
+		return "data"
+	}
+	return fmt.Sprintf("DecodePhase(%d)", int(p))
+}
+
+// Error reports the phase, offset, and cause of the error.
+func (e *DecodeError) Error() string {
+	return fmt.Sprintf("Cannot decode %s at byte %d: %v", e.Phase, e.Offset, e.Err)
+}
+
+// Unwrap returns the cause of the error.
+func (e *DecodeError) Unwrap() error {
+	return e.Err
+}
+
+// Is reports truncated pixels as io.ErrUnexpectedEOF, like the other phases.
+func (e *DecodeError) Is(target error) bool {
+	return target == io.ErrUnexpectedEOF && e.Err == ErrTruncatedPixels
+}
+
+// NewDecodeError creates a DecodeError. If the reader ended, the cause is
+// io.ErrUnexpectedEOF, or ErrTruncatedPixels for the pixels.
+func newDecodeError(phase DecodePhase, offset int64, err error) error {
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	if phase == PhasePixels && err == io.ErrUnexpectedEOF {
+		err = ErrTruncatedPixels
+	}
+	return &DecodeError{phase, offset, err}
+}
+
+// ShiftOffset adds the offset to a DecodeError, for images that are decoded
+// after other data of a format.
+func shiftOffset(err error, offset int64) error {
+	if decodeErr, ok := err.(*DecodeError); ok {
+		shifted := *decodeErr
+		shifted.Offset += offset
+		return &shifted
+	}
+	return err
+}
+
+// OffsetReader counts the bytes that have been read, so that errors can
+// report where they occurred.
+type offsetReader struct {
+	io.Reader
+	offset int64
+}
+
+// Read reads from the reader and counts the bytes.
+func (r *offsetReader) Read(p []byte) (n int, err error) {
+	n, err = r.Reader.Read(p)
+	r.offset += int64(n)
+	return
 }
 
 func init() {
@@ -2,13 +2,16 @@ package imretro
 
 import (
 	"bytes"
+	"errors"
 	"image"
 	"image/color"
 	"io"
+	"reflect"
 	"testing"
 
 	"github.com/imretro/go/internal/util"
 	"github.com/spenserblack/go-bitio"
+	"github.com/spenserblack/go-byteutils"
 )
 
 // TestPassCheckHeader tests that a reader starting with "IMRETRO" bytes will
@@ -40,13 +43,15 @@ func TestFailCheckHeader(t *testing.T) {
 	jpgSignature := "\xFF\xD8\xFF\xE0\x00\x10\x4A\x46\x49\x46\x00\x01"
 
 	partialr := bytes.NewBufferString(partialSignature)
-	if _, err := checkHeader(partialr, buff); err != io.ErrUnexpectedEOF {
-		t.Errorf(`err = %v, want %v`, err, io.ErrUnexpectedEOF)
+	_, err := checkHeader(partialr, buff)
+	if want := (&DecodeError{PhaseSignature, 5, io.ErrUnexpectedEOF}); !reflect.DeepEqual(err, want) {
+		t.Errorf(`err = %v, want %v`, err, want)
 	}
 
 	jpgr := bytes.NewBufferString(jpgSignature)
-	if _, err := checkHeader(jpgr, buff); err != DecodeError("unexpected signature byte") {
-		t.Fatalf(`err = %v, want %v`, err, DecodeError("unexpected signature byte"))
+	_, err = checkHeader(jpgr, buff)
+	if want := (&DecodeError{PhaseSignature, 0, ErrBadSignature}); !reflect.DeepEqual(err, want) {
+		t.Fatalf(`err = %v, want %v`, err, want)
 	}
 }
 
@@ -656,17 +661,103 @@ func TestDecodeMissingModel(t *testing.T) {
 
 	r = MakeImretroReader(OneBit|EightBitColors, nil, 1, 1, []byte{0})
 	_, err = Decode(r, ModelMap{})
-	if want := MissingModelError(0); err != want {
+	if want := MissingModelError(0); !errors.Is(err, want) {
 		t.Errorf(`err = %v, want %v`, err, want)
 	}
 
 	r = MakeImretroReader(0b1110_0001, nil, 1, 1, []byte{0})
 	_, err = DecodeConfig(r, nil)
-	if want := MissingModelError(0b1100_0000); err != want {
+	if want := MissingModelError(0b1100_0000); !errors.Is(err, want) {
 		t.Errorf(`err = %v, want %v`, err, want)
 	}
 }
 
+// TestDecodeInvalidMode tests that mode bytes with reserved bits or invalid
+// palette channels are rejected.
+func TestDecodeInvalidMode(t *testing.T) {
+	tests := []byte{
+		OneBit | 0b1000,
+		TwoBit | 0b1_0000,
+		WithPalette | 0b110,
+		WithPalette | 0b111,
+	}
+	for _, mode := range tests {
+		r := MakeImretroReader(mode, nil, 1, 1, make([]byte, 32))
+		if _, err := Decode(r, nil); !errors.Is(err, InvalidModeError(mode)) {
+			t.Errorf(`mode 0b%08b: err = %v, want %v`, mode, err, InvalidModeError(mode))
+		}
+	}
+
+	r := MakeImretroReader(OneBit|0b110, nil, 1, 1, []byte{0})
+	if _, err := Decode(r, nil); err != nil {
+		t.Errorf(`err = %v, want nil`, err)
+	}
+
+	if s := InvalidModeError(0b1000).Error(); s != "Invalid mode byte: 0b1000" {
+		t.Errorf(`Error() = %q`, s)
+	}
+}
+
+// TestDecodeInvalidCustomModel tests that custom models that cannot be used
+// for the pixel mode are rejected instead of causing a panic.
+func TestDecodeInvalidCustomModel(t *testing.T) {
+	tests := []struct {
+		mode   PixelMode
+		models ModelMap
+		want   error
+	}{
+		{OneBit, ModelMap{OneBit: color.GrayModel}, ErrUnknownModel},
+		{OneBit, ModelMap{OneBit: Default2BitColorModel}, ModelSizeError{OneBit, 4}},
+		{0b1100_0000, ModelMap{0b1100_0000: Default1BitColorModel}, MissingModelError(0b1100_0000)},
+	}
+	for _, tt := range tests {
+		r := MakeImretroReader(tt.mode, nil, 1, 1, []byte{0})
+		if _, err := Decode(r, tt.models); !errors.Is(err, tt.want) {
+			t.Errorf(`err = %v, want %v`, err, tt.want)
+		}
+	}
+
+	r := MakeImretroReader(TwoBit, nil, 2, 1, []byte{0b0011_0000})
+	i, err := Decode(r, ModelMap{TwoBit: ColorModel{black, white}})
+	if err != nil {
+		t.Fatalf(`err = %v, want nil`, err)
+	}
+	CompareColors(t, i.At(0, 0), black)
+	CompareColors(t, i.At(1, 0), noColor)
+}
+
+// TestDecodeLimits tests that images larger than the limits are not decoded.
+func TestDecodeLimits(t *testing.T) {
+	tests := []struct {
+		options DecodeOptions
+		want    error
+	}{
+		{DecodeOptions{MaxWidth: 9}, LimitError{"width", 9, 10}},
+		{DecodeOptions{MaxHeight: 3}, LimitError{"height", 3, 4}},
+		{DecodeOptions{MaxArea: 39}, LimitError{"area", 39, 40}},
+		// NOTE 5 bytes for the pixels and 2 RGBA colors.
+		{DecodeOptions{MaxAlloc: 12}, LimitError{"allocation", 12, 13}},
+		{DecodeOptions{MaxWidth: 10, MaxHeight: 4, MaxArea: 40, MaxAlloc: 13}, nil},
+	}
+	for i, tt := range tests {
+		r := MakeImretroReader(OneBit|WithPalette|RGBA|EightBitColors, [][]byte{{0, 0, 0, 0}, {1, 1, 1, 1}}, 10, 4, make([]byte, 5))
+		if _, err := DecodeWithOptions(r, tt.options); !errors.Is(err, tt.want) {
+			t.Errorf(`test %d: err = %v, want %v`, i, err, tt.want)
+		}
+	}
+
+	// NOTE The limits are checked before the palette is read.
+	r := MakeImretroReader(EightBit|WithPalette|RGBA|EightBitColors, nil, 0xFFF, 0xFFF, nil)
+	want := LimitError{"allocation", 1 << 20, 0xFFF*0xFFF + 1024}
+	if _, err := DecodeConfigWithOptions(r, DecodeOptions{MaxAlloc: 1 << 20}); !errors.Is(err, want) {
+		t.Errorf(`err = %v, want %v`, err, want)
+	}
+
+	if s := want.Error(); s != "Image allocation of 16770049 exceeds the limit of 1048576" {
+		t.Errorf(`Error() = %q`, s)
+	}
+}
+
 // TestDecodeNotEnoughPixels tests that the decoder will return an error if
 // there are not enough pixels for the dimensions.
 //
@@ -689,6 +780,115 @@ func TestDecodeNotEnoughPixels(t *testing.T) {
 	}
 }
 
+// TestDecodeLenient tests that the pixels that are missing from an image are
+// filled when the Lenient option is set.
+func TestDecodeLenient(t *testing.T) {
+	tests := []struct {
+		mode      PixelMode
+		fillIndex uint8
+		pixels    []byte
+	}{
+		{OneBit, 1, []byte{0b0101_0101}},
+		{TwoBit, 2, []byte{0b1110_0100}},
+		{TwoBit, 0, nil},
+		{EightBit, 7, []byte{1, 2, 3, 4, 5}},
+	}
+	for _, tt := range tests {
+		r := MakeImretroReader(tt.mode, nil, 4, 4, tt.pixels)
+		m, err := DecodeWithOptions(r, DecodeOptions{Lenient: true, FillIndex: tt.fillIndex})
+		bits := DefaultModelMap[tt.mode].(ColorModel).BitsPerPixel()
+		wantErr := PartialImageError{int64(11 + len(tt.pixels)), 16*bits/8 - len(tt.pixels)}
+		if err != wantErr {
+			t.Fatalf(`mode 0b%08b: err = %v, want %v`, tt.mode, err, wantErr)
+		}
+		if !errors.Is(err, ErrTruncatedPixels) {
+			t.Errorf(`err = %v, want %v`, err, ErrTruncatedPixels)
+		}
+		readPixels := len(tt.pixels) * 8 / bits
+		for i := 0; i < 16; i++ {
+			x, y := i%4, i/4
+			want := tt.fillIndex
+			if i < readPixels {
+				want = byteutils.SliceL(tt.pixels[i*bits/8], byte(i*bits%8), byte(i*bits%8+bits))
+			}
+			if actual := m.ColorIndexAt(x, y); actual != want {
+				t.Errorf(`mode 0b%08b: index at (%d, %d) = %d, want %d`, tt.mode, x, y, actual, want)
+			}
+		}
+	}
+
+	if s := (PartialImageError{12, 3}).Error(); s != "Image is missing 3 bytes of pixels after byte 12" {
+		t.Errorf(`Error() = %q`, s)
+	}
+
+	r := MakeImretroReader(OneBit, nil, 4, 4, []byte{0, 0})
+	if _, err := DecodeWithOptions(r, DecodeOptions{Lenient: true}); err != nil {
+		t.Errorf(`err = %v, want nil`, err)
+	}
+}
+
+// TestValidate tests that Validate reports the problems that the decoder
+// ignores.
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		data []byte
+		want []Problem
+	}{
+		{MakeImretroReader(TwoBit, nil, 3, 1, []byte{0b0110_1100}).Bytes(), []Problem{}},
+		{
+			MakeImretroReader(TwoBit|0b1_1000|RGB, nil, 3, 1, []byte{0b0110_1101, 0}).Bytes(),
+			[]Problem{
+				{PhaseMode, 7, InvalidModeError(TwoBit | 0b1_1000 | RGB)},
+				{PhaseMode, 7, ErrUnusedPaletteFlags},
+				{PhasePixels, 11, ErrPaddingBits},
+				{PhaseTrailingData, 12, ErrTrailingData},
+			},
+		},
+		{
+			MakeImretroReader(OneBit|EightBitColors, nil, 4, 4, []byte{0}).Bytes(),
+			[]Problem{
+				{PhaseMode, 7, ErrUnusedPaletteFlags},
+				{PhasePixels, 12, ErrTruncatedPixels},
+			},
+		},
+		{[]byte("IMRETRX"), []Problem{{PhaseSignature, 6, ErrBadSignature}}},
+	}
+	for i, tt := range tests {
+		if actual := Validate(bytes.NewBuffer(tt.data)); !reflect.DeepEqual(actual, tt.want) {
+			t.Errorf(`test %d: Validate() = %v, want %v`, i, actual, tt.want)
+		}
+	}
+
+	p := Problem{PhaseTrailingData, 12, ErrTrailingData}
+	if s := p.String(); s != "Cannot decode trailing data at byte 12: Unexpected data after the pixels" {
+		t.Errorf(`String() = %q`, s)
+	}
+}
+
+// TestDecodeStrict tests that the problems reported by Validate are errors in
+// strict mode.
+func TestDecodeStrict(t *testing.T) {
+	tests := []struct {
+		data []byte
+		want error
+	}{
+		{MakeImretroReader(OneBit|RGBA, nil, 1, 1, []byte{0}).Bytes(), ErrUnusedPaletteFlags},
+		{MakeImretroReader(OneBit, nil, 1, 1, []byte{0b0100_0000}).Bytes(), ErrPaddingBits},
+		{MakeImretroReader(OneBit, nil, 8, 1, []byte{0, 0}).Bytes(), ErrTrailingData},
+		{MakeImretroReader(OneBit|0b1000, nil, 8, 1, []byte{0}).Bytes(), ErrInvalidMode},
+		{MakeImretroReader(OneBit, nil, 1, 1, []byte{0b1000_0000}).Bytes(), nil},
+	}
+	for i, tt := range tests {
+		_, err := DecodeWithOptions(bytes.NewBuffer(tt.data), DecodeOptions{Strict: true})
+		if !errors.Is(err, tt.want) {
+			t.Errorf(`test %d: err = %v, want %v`, i, err, tt.want)
+		}
+		if _, err := Decode(bytes.NewBuffer(tt.data), nil); tt.want != ErrInvalidMode && err != nil {
+			t.Errorf(`test %d: err = %v, want nil without strict mode`, i, err)
+		}
+	}
+}
+
 // TestDecodeReaderError tests that a reader error would be returned if it
 // occurs.
 func TestDecodeReaderError(t *testing.T) {
@@ -729,9 +929,127 @@ func TestDecodeReaderError(t *testing.T) {
 // TestDecodeError tests that the proper string representation of a failure to
 // decode is returned.
 func TestDecodeError(t *testing.T) {
-	err := DecodeError("Failed!")
-	if s := err.Error(); s != "Failed!" {
-		t.Fatalf(`Error() = %q, want "Failed!"`, s)
+	err := &DecodeError{PhasePalette, 12, io.ErrUnexpectedEOF}
+	if s := err.Error(); s != "Cannot decode palette at byte 12: unexpected EOF" {
+		t.Fatalf(`Error() = %q, want "Cannot decode palette at byte 12: unexpected EOF"`, s)
+	}
+	if s := DecodePhase(9).String(); s != "DecodePhase(9)" {
+		t.Errorf(`String() = %q, want "DecodePhase(9)"`, s)
+	}
+}
+
+// TestDecodeErrorPhases tests that decoding errors record where they occurred
+// and match the sentinel errors.
+func TestDecodeErrorPhases(t *testing.T) {
+	palette := [][]byte{{0, 0, 0, 0}, {1, 1, 1, 1}}
+	tests := []struct {
+		data   []byte
+		phase  DecodePhase
+		offset int64
+		is     error
+	}{
+		{[]byte("IMRXTRO"), PhaseSignature, 3, ErrBadSignature},
+		{[]byte("IMRETRO"), PhaseMode, 7, io.ErrUnexpectedEOF},
+		{MakeImretroReader(OneBit|0b1000, nil, 1, 1, nil).Bytes(), PhaseMode, 7, ErrInvalidMode},
+		{MakeImretroReader(0b1100_0000, nil, 1, 1, nil).Bytes(), PhaseMode, 7, ErrInvalidMode},
+		{MakeImretroReader(OneBit, nil, 1, 1, nil).Bytes()[:10], PhaseDimensions, 10, io.ErrUnexpectedEOF},
+		{MakeImretroReader(OneBit|WithPalette|RGBA|EightBitColors, palette, 1, 1, nil).Bytes()[:17], PhasePalette, 17, io.ErrUnexpectedEOF},
+		{MakeImretroReader(TwoBit, nil, 4, 4, []byte{0, 0, 0}).Bytes(), PhasePixels, 14, ErrTruncatedPixels},
+	}
+	for i, tt := range tests {
+		_, err := Decode(bytes.NewBuffer(tt.data), nil)
+		var decodeErr *DecodeError
+		if !errors.As(err, &decodeErr) {
+			t.Fatalf(`test %d: err = %v, want a DecodeError`, i, err)
+		}
+		if decodeErr.Phase != tt.phase || decodeErr.Offset != tt.offset {
+			t.Errorf(`test %d: phase %v at %d, want phase %v at %d`, i, decodeErr.Phase, decodeErr.Offset, tt.phase, tt.offset)
+		}
+		if !errors.Is(err, tt.is) {
+			t.Errorf(`test %d: err = %v, want %v`, i, err, tt.is)
+		}
+	}
+
+	_, err := Decode(MakeImretroReader(OneBit, nil, 8, 1, nil), nil)
+	if !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Errorf(`err = %v, want %v`, err, io.ErrUnexpectedEOF)
+	}
+	if _, err := Decode(MakeImretroReader(OneBit, nil, 1, 1, nil), ModelMap{}); errors.Is(err, ErrInvalidMode) {
+		t.Errorf(`err = %v, want not %v`, err, ErrInvalidMode)
+	}
+}
+
+// TestDecoderRows tests that the rows of a Decoder have the same indices as
+// the image from Decode.
+func TestDecoderRows(t *testing.T) {
+	tests := []struct {
+		mode          PixelMode
+		width, height uint16
+	}{
+		{OneBit, 3, 5},
+		{TwoBit, 5, 3},
+		{TwoBit, 4, 2},
+		{EightBit, 3, 2},
+		{OneBit, 0, 2},
+	}
+	for _, tt := range tests {
+		bits := int(tt.width) * int(tt.height) * DefaultModelMap[tt.mode].(ColorModel).BitsPerPixel()
+		pixels := make([]byte, (bits+7)/8)
+		for i := range pixels {
+			pixels[i] = byte(i*0x3B + 0x5A)
+		}
+		want, err := Decode(MakeImretroReader(tt.mode, nil, tt.width, tt.height, pixels), nil)
+		if err != nil {
+			t.Fatalf(`err = %v, want nil`, err)
+		}
+
+		d, err := NewDecoder(MakeImretroReader(tt.mode, nil, tt.width, tt.height, pixels), nil)
+		if err != nil {
+			t.Fatalf(`err = %v, want nil`, err)
+		}
+		if config := d.Config(); config.Width != int(tt.width) || config.Height != int(tt.height) {
+			t.Errorf(`size = %dx%d, want %dx%d`, config.Width, config.Height, tt.width, tt.height)
+		}
+		for y := 0; y < int(tt.height); y++ {
+			row, err := d.NextRow()
+			if err != nil {
+				t.Fatalf(`row %d: err = %v, want nil`, y, err)
+			}
+			for x, actual := range row {
+				if index := want.ColorIndexAt(x, y); actual != index {
+					t.Errorf(`mode 0b%08b: index at (%d, %d) = %d, want %d`, tt.mode, x, y, actual, index)
+				}
+			}
+		}
+		if _, err := d.NextRow(); err != io.EOF {
+			t.Errorf(`err = %v, want %v`, err, io.EOF)
+		}
+	}
+}
+
+// TestDecoderNotEnoughPixels tests that a Decoder returns an error if the
+// pixels end before the last row.
+func TestDecoderNotEnoughPixels(t *testing.T) {
+	d, err := NewDecoder(MakeImretroReader(TwoBit, nil, 3, 3, []byte{0, 0}), nil)
+	if err != nil {
+		t.Fatalf(`err = %v, want nil`, err)
+	}
+	for y := 0; y < 2; y++ {
+		if _, err := d.NextRow(); err != nil {
+			t.Fatalf(`row %d: err = %v, want nil`, y, err)
+		}
+	}
+	_, err = d.NextRow()
+	if !errors.Is(err, ErrTruncatedPixels) || !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Fatalf(`err = %v, want %v`, err, ErrTruncatedPixels)
+	}
+	var decodeErr *DecodeError
+	if !errors.As(err, &decodeErr) || decodeErr.Phase != PhasePixels {
+		t.Errorf(`err = %#v, want pixels error`, err)
+	}
+
+	if _, err := NewDecoder(bytes.NewBuffer(nil), nil); err == nil {
+		t.Fatalf(`err = nil`)
 	}
 }
 
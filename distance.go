@@ -0,0 +1,221 @@
+package imretro
+
+import (
+	"image/color"
+	"math"
+)
+
+// ColorDistance measures how different two colors are. Identical colors should
+// have a distance of 0, and a larger distance means that the colors are less
+// alike.
+type ColorDistance func(c1, c2 color.Color) float64
+
+// EuclideanDistance is the straight-line distance between two colors in RGBA
+// space, with each channel normalized to [0, 1]. The colors are compared as
+// alpha-premultiplied values, so transparent colors are close to each other
+// regardless of their color channels.
+func EuclideanDistance(c1, c2 color.Color) float64 {
+	r1, g1, b1, a1 := c1.RGBA()
+	r2, g2, b2, a2 := c2.RGBA()
+	dr := channelDiff(r1, r2)
+	dg := channelDiff(g1, g2)
+	db := channelDiff(b1, b2)
+	da := channelDiff(a1, a2)
+	return math.Sqrt(dr*dr + dg*dg + db*db + da*da)
+}
+
+// ChannelDiff returns the difference between two channels ranging within
+// [0, 0xFFFF], normalized to [-1, 1].
+func channelDiff(c1, c2 uint32) float64 {
+	return (float64(c1) - float64(c2)) / 0xFFFF
+}
+
+// RedmeanDistance is a weighted Euclidean distance that approximates human
+// perception by weighing the red, green, and blue channels based on the
+// average amount of red in the two colors. Alpha differences are weighed the
+// same as green differences.
+func RedmeanDistance(c1, c2 color.Color) float64 {
+	r1, g1, b1, a1 := c1.RGBA()
+	r2, g2, b2, a2 := c2.RGBA()
+	redmean := (float64(r1) + float64(r2)) / (2 * 0xFFFF)
+	dr := channelDiff(r1, r2)
+	dg := channelDiff(g1, g2)
+	db := channelDiff(b1, b2)
+	da := channelDiff(a1, a2)
+	return math.Sqrt((2+redmean)*dr*dr + 4*dg*dg + (3-redmean)*db*db + 4*da*da)
+}
+
+// CIE76Distance is the CIE 1976 color difference (ΔE*ab), which is the
+// Euclidean distance between colors in the CIELAB color space. Colors are
+// composited over black, and alpha differences are added as a lightness
+// difference, so that fully opaque and fully transparent colors have a
+// distance of at least 100.
+func CIE76Distance(c1, c2 color.Color) float64 {
+	lab1, a1 := toLab(c1)
+	lab2, a2 := toLab(c2)
+	dl := lab1.l - lab2.l
+	da := lab1.a - lab2.a
+	db := lab1.b - lab2.b
+	return withAlpha(math.Sqrt(dl*dl+da*da+db*db), a1-a2, 100)
+}
+
+// CIEDE2000Distance is the CIEDE2000 color difference (ΔE00), which corrects
+// the perceptual non-uniformities of CIE76Distance, especially for blues and
+// saturated colors. Transparency is handled like CIE76Distance.
+func CIEDE2000Distance(c1, c2 color.Color) float64 {
+	lab1, a1 := toLab(c1)
+	lab2, a2 := toLab(c2)
+	return withAlpha(ciede2000(lab1, lab2), a1-a2, 100)
+}
+
+// OKLabDistance is the Euclidean distance between colors in the OKLab color
+// space, which is more perceptually uniform than CIELAB while being much
+// cheaper to compute than CIEDE2000Distance. Colors are composited over black,
+// and alpha differences are added as a lightness difference.
+func OKLabDistance(c1, c2 color.Color) float64 {
+	lab1, a1 := toOKLab(c1)
+	lab2, a2 := toOKLab(c2)
+	dl := lab1.l - lab2.l
+	da := lab1.a - lab2.a
+	db := lab1.b - lab2.b
+	return withAlpha(math.Sqrt(dl*dl+da*da+db*db), a1-a2, 1)
+}
+
+// Lab is a color in a lightness/opponent-color space like CIELAB or OKLab.
+type lab struct {
+	l, a, b float64
+}
+
+// WithAlpha combines a color distance with an alpha difference. The alpha
+// difference, ranging within [-1, 1], is scaled to the lightness range of the
+// color space.
+func withAlpha(distance, alphaDiff, scale float64) float64 {
+	alphaDistance := alphaDiff * scale
+	return math.Sqrt(distance*distance + alphaDistance*alphaDistance)
+}
+
+// LinearRGB converts a color to linear RGB channels ranging within [0, 1], and
+// returns its alpha. The color is composited over black.
+func linearRGB(c color.Color) (r, g, b, a float64) {
+	rchan, gchan, bchan, achan := c.RGBA()
+	return linearize(rchan), linearize(gchan), linearize(bchan), float64(achan) / 0xFFFF
+}
+
+// Linearize removes the sRGB gamma from a channel ranging within [0, 0xFFFF].
+func linearize(channel uint32) float64 {
+	v := float64(channel) / 0xFFFF
+	if v <= 0.04045 {
+		return v / 12.92
+	}
+	return math.Pow((v+0.055)/1.055, 2.4)
+}
+
+// ToLab converts a color to CIELAB using the D65 white point.
+func toLab(c color.Color) (lab, float64) {
+	r, g, b, alpha := linearRGB(c)
+	x := (0.4124564*r + 0.3575761*g + 0.1804375*b) / 0.95047
+	y := 0.2126729*r + 0.7151522*g + 0.0721750*b
+	z := (0.0193339*r + 0.1191920*g + 0.9503041*b) / 1.08883
+	fx, fy, fz := labF(x), labF(y), labF(z)
+	return lab{116*fy - 16, 500 * (fx - fy), 200 * (fy - fz)}, alpha
+}
+
+// LabF is the nonlinear function used when converting from XYZ to CIELAB.
+func labF(t float64) float64 {
+	const delta = 6.0 / 29.0
+	if t > delta*delta*delta {
+		return math.Cbrt(t)
+	}
+	return t/(3*delta*delta) + 4.0/29.0
+}
+
+// ToOKLab converts a color to OKLab.
+func toOKLab(c color.Color) (lab, float64) {
+	r, g, b, alpha := linearRGB(c)
+	l := math.Cbrt(0.4122214708*r + 0.5363325363*g + 0.0514459929*b)
+	m := math.Cbrt(0.2119034982*r + 0.6806995451*g + 0.1073969566*b)
+	s := math.Cbrt(0.0883024619*r + 0.2817188376*g + 0.6299787005*b)
+	return lab{
+		0.2104542553*l + 0.7936177850*m - 0.0040720468*s,
+		1.9779984951*l - 2.4285922050*m + 0.4505937099*s,
+		0.0259040371*l + 0.7827717662*m - 0.8086757660*s,
+	}, alpha
+}
+
+// Ciede2000 calculates the CIEDE2000 color difference between two CIELAB
+// colors.
+func ciede2000(lab1, lab2 lab) float64 {
+	const pow25To7 = 6103515625.0 // 25^7
+	c1 := math.Hypot(lab1.a, lab1.b)
+	c2 := math.Hypot(lab2.a, lab2.b)
+	cMean7 := math.Pow((c1+c2)/2, 7)
+	g := 0.5 * (1 - math.Sqrt(cMean7/(cMean7+pow25To7)))
+	a1 := lab1.a * (1 + g)
+	a2 := lab2.a * (1 + g)
+	c1 = math.Hypot(a1, lab1.b)
+	c2 = math.Hypot(a2, lab2.b)
+	h1 := hueAngle(lab1.b, a1)
+	h2 := hueAngle(lab2.b, a2)
+
+	dl := lab2.l - lab1.l
+	dc := c2 - c1
+	var dh float64
+	if c1*c2 != 0 {
+		dh = h2 - h1
+		if dh > 180 {
+			dh -= 360
+		} else if dh < -180 {
+			dh += 360
+		}
+	}
+	dH := 2 * math.Sqrt(c1*c2) * math.Sin(radians(dh/2))
+
+	lMean := (lab1.l + lab2.l) / 2
+	cMean := (c1 + c2) / 2
+	hMean := h1 + h2
+	if c1*c2 != 0 {
+		if math.Abs(h1-h2) > 180 {
+			if hMean < 360 {
+				hMean += 360
+			} else {
+				hMean -= 360
+			}
+		}
+		hMean /= 2
+	}
+
+	t := 1 - 0.17*math.Cos(radians(hMean-30)) +
+		0.24*math.Cos(radians(2*hMean)) +
+		0.32*math.Cos(radians(3*hMean+6)) -
+		0.20*math.Cos(radians(4*hMean-63))
+	lMean50 := (lMean - 50) * (lMean - 50)
+	sl := 1 + 0.015*lMean50/math.Sqrt(20+lMean50)
+	sc := 1 + 0.045*cMean
+	sh := 1 + 0.015*cMean*t
+	cMean7 = math.Pow(cMean, 7)
+	rc := 2 * math.Sqrt(cMean7/(cMean7+pow25To7))
+	dTheta := 30 * math.Exp(-((hMean-275)/25)*((hMean-275)/25))
+	rt := -math.Sin(radians(2*dTheta)) * rc
+
+	lTerm := dl / sl
+	cTerm := dc / sc
+	hTerm := dH / sh
+	return math.Sqrt(lTerm*lTerm + cTerm*cTerm + hTerm*hTerm + rt*cTerm*hTerm)
+}
+
+// HueAngle returns the hue angle in degrees, ranging within [0, 360).
+func hueAngle(b, a float64) float64 {
+	if a == 0 && b == 0 {
+		return 0
+	}
+	h := math.Atan2(b, a) * 180 / math.Pi
+	if h < 0 {
+		h += 360
+	}
+	return h
+}
+
+// Radians converts degrees to radians.
+func radians(degrees float64) float64 {
+	return degrees * math.Pi / 180
+}
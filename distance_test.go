@@ -0,0 +1,119 @@
+package imretro
+
+import (
+	"image/color"
+	"math"
+	"testing"
+)
+
+// TestEuclideanDistance tests the distance between colors in RGBA space.
+func TestEuclideanDistance(t *testing.T) {
+	tests := []struct {
+		c1, c2 color.Color
+		want   float64
+	}{
+		{black, black, 0},
+		{black, white, math.Sqrt(3)},
+		{color.RGBA{0xFF, 0, 0, 0xFF}, color.RGBA{0, 0xFF, 0, 0xFF}, math.Sqrt(2)},
+		{color.Alpha{0}, color.RGBA{0, 0, 0, 0}, 0},
+		{noColor, black, 1},
+	}
+
+	for _, tt := range tests {
+		if actual := EuclideanDistance(tt.c1, tt.c2); math.Abs(actual-tt.want) > 1e-9 {
+			t.Errorf(`EuclideanDistance(%v, %v) = %v, want %v`, tt.c1, tt.c2, actual, tt.want)
+		}
+	}
+}
+
+// TestCIEDE2000 tests the CIEDE2000 color difference against reference values
+// from Sharma, Wu, and Dalal's test data.
+func TestCIEDE2000(t *testing.T) {
+	tests := []struct {
+		lab1, lab2 lab
+		want       float64
+	}{
+		{lab{50, 2.6772, -79.7751}, lab{50, 0, -82.7485}, 2.0425},
+		{lab{50, -1.3802, -84.2814}, lab{50, 0, -82.7485}, 1.0000},
+		{lab{50, 0, 0}, lab{50, -1, 2}, 2.3669},
+		{lab{50, 2.5, 0}, lab{73, 25, -18}, 27.1492},
+		{lab{50, 2.5, 0}, lab{50, 3.1736, 0.5854}, 1.0000},
+		{lab{60.2574, -34.0099, 36.2677}, lab{60.4626, -34.1751, 39.4387}, 1.2644},
+		{lab{90.8027, -2.0831, 1.441}, lab{91.1528, -1.6435, 0.0447}, 1.4441},
+	}
+
+	for _, tt := range tests {
+		if actual := ciede2000(tt.lab1, tt.lab2); math.Abs(actual-tt.want) > 1e-4 {
+			t.Errorf(`ciede2000(%v, %v) = %.4f, want %.4f`, tt.lab1, tt.lab2, actual, tt.want)
+		}
+	}
+}
+
+// TestPerceptualDistances tests that the perceptual distances share the basic
+// properties of a distance.
+func TestPerceptualDistances(t *testing.T) {
+	distances := map[string]ColorDistance{
+		"redmean":   RedmeanDistance,
+		"CIE76":     CIE76Distance,
+		"CIEDE2000": CIEDE2000Distance,
+		"OKLab":     OKLabDistance,
+	}
+	red := color.RGBA{0xFF, 0, 0, 0xFF}
+	darkRed := color.RGBA{0x80, 0, 0, 0xFF}
+
+	for name, distance := range distances {
+		t.Logf(`Testing %s`, name)
+		if d := distance(red, red); d != 0 {
+			t.Errorf(`distance to self = %v, want 0`, d)
+		}
+		if d := distance(color.Alpha{0}, color.RGBA{0, 0, 0, 0}); d != 0 {
+			t.Errorf(`distance between transparent colors = %v, want 0`, d)
+		}
+		if d1, d2 := distance(red, darkRed), distance(darkRed, red); math.Abs(d1-d2) > 1e-9 {
+			t.Errorf(`distance is not symmetric: %v != %v`, d1, d2)
+		}
+		if near, far := distance(red, darkRed), distance(red, white); near >= far {
+			t.Errorf(`distance to dark red (%v) >= distance to white (%v)`, near, far)
+		}
+		if d := distance(black, noColor); d <= 0 {
+			t.Errorf(`distance between opaque and transparent black = %v, want > 0`, d)
+		}
+	}
+}
+
+// TestLab tests that colors are converted to CIELAB.
+func TestLab(t *testing.T) {
+	tests := []struct {
+		c    color.Color
+		want lab
+	}{
+		{white, lab{100, 0, 0}},
+		{black, lab{0, 0, 0}},
+		{color.RGBA{0xFF, 0, 0, 0xFF}, lab{53.2408, 80.0925, 67.2032}},
+	}
+
+	for _, tt := range tests {
+		actual, _ := toLab(tt.c)
+		if math.Abs(actual.l-tt.want.l) > 1e-2 || math.Abs(actual.a-tt.want.a) > 1e-2 || math.Abs(actual.b-tt.want.b) > 1e-2 {
+			t.Errorf(`toLab(%v) = %v, want %v`, tt.c, actual, tt.want)
+		}
+	}
+}
+
+// TestOKLab tests that colors are converted to OKLab.
+func TestOKLab(t *testing.T) {
+	tests := []struct {
+		c    color.Color
+		want lab
+	}{
+		{white, lab{1, 0, 0}},
+		{color.RGBA{0xFF, 0, 0, 0xFF}, lab{0.62796, 0.22486, 0.12585}},
+	}
+
+	for _, tt := range tests {
+		actual, _ := toOKLab(tt.c)
+		if math.Abs(actual.l-tt.want.l) > 1e-3 || math.Abs(actual.a-tt.want.a) > 1e-3 || math.Abs(actual.b-tt.want.b) > 1e-3 {
+			t.Errorf(`toOKLab(%v) = %v, want %v`, tt.c, actual, tt.want)
+		}
+	}
+}
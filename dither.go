@@ -0,0 +1,374 @@
+package imretro
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+)
+
+// Ditherer maps the pixels of an image to the colors of a model, spreading out
+// the difference between the actual and the available colors so that areas of
+// the image keep their average color.
+type Ditherer interface {
+	// Dither returns the index in the model of each pixel of m. The returned
+	// image has the same bounds as m. Colors are matched to the closest color
+	// according to distance, or with ColorModel.Index if distance is nil.
+	Dither(m image.Image, model ColorModel, distance ColorDistance) image.PalettedImage
+}
+
+// ErrorDiffusion is a Ditherer that spreads the error of each quantized pixel to
+// the neighboring pixels that have not been quantized yet.
+type ErrorDiffusion struct {
+	// Matrix is the weights of the neighboring pixels, starting with the row of
+	// the current pixel. The current pixel is at column Offset of the first
+	// row, and the weights before and at the current pixel are ignored.
+	Matrix [][]float64
+	// Offset is the column of the current pixel in the first row of Matrix.
+	Offset int
+	// Divisor is the value each weight is divided by. It is usually the sum
+	// of the weights. If it is 0, or if Matrix is empty, the error is not
+	// diffused.
+	Divisor float64
+}
+
+// Error diffusion kernels.
+var (
+	// FloydSteinberg is the Floyd-Steinberg kernel.
+	FloydSteinberg = ErrorDiffusion{
+		Matrix: [][]float64{
+			{0, 0, 7},
+			{3, 5, 1},
+		},
+		Offset:  1,
+		Divisor: 16,
+	}
+	// Atkinson is the Atkinson kernel. It only diffuses 3/4 of the error,
+	// which gives more contrast but loses detail in very light and dark areas.
+	Atkinson = ErrorDiffusion{
+		Matrix: [][]float64{
+			{0, 0, 1, 1},
+			{1, 1, 1, 0},
+			{0, 1, 0, 0},
+		},
+		Offset:  1,
+		Divisor: 8,
+	}
+	// JarvisJudiceNinke is the Jarvis, Judice, and Ninke kernel.
+	JarvisJudiceNinke = ErrorDiffusion{
+		Matrix: [][]float64{
+			{0, 0, 0, 7, 5},
+			{3, 5, 7, 5, 3},
+			{1, 3, 5, 3, 1},
+		},
+		Offset:  2,
+		Divisor: 48,
+	}
+	// Stucki is the Stucki kernel.
+	Stucki = ErrorDiffusion{
+		Matrix: [][]float64{
+			{0, 0, 0, 8, 4},
+			{2, 4, 8, 4, 2},
+			{1, 2, 4, 2, 1},
+		},
+		Offset:  2,
+		Divisor: 42,
+	}
+	// Burkes is the Burkes kernel.
+	Burkes = ErrorDiffusion{
+		Matrix: [][]float64{
+			{0, 0, 0, 8, 4},
+			{2, 4, 8, 4, 2},
+		},
+		Offset:  2,
+		Divisor: 32,
+	}
+	// Sierra is the three-row Sierra kernel.
+	Sierra = ErrorDiffusion{
+		Matrix: [][]float64{
+			{0, 0, 0, 5, 3},
+			{2, 4, 5, 4, 2},
+			{0, 2, 3, 2, 0},
+		},
+		Offset:  2,
+		Divisor: 32,
+	}
+)
+
+// Dither quantizes each pixel from left to right and top to bottom, and
+// diffuses its error according to the kernel.
+func (d ErrorDiffusion) Dither(m image.Image, model ColorModel, distance ColorDistance) image.PalettedImage {
+	indexer := newColorIndexer(model, distance)
+	bounds := m.Bounds()
+	dst := image.NewPaletted(bounds, color.Palette(model))
+	width := bounds.Dx()
+	if len(d.Matrix) == 0 || d.Divisor == 0 {
+		d = ErrorDiffusion{Matrix: [][]float64{{0}}, Divisor: 1}
+	}
+
+	// NOTE Only the rows covered by the kernel need to store errors.
+	rows := make([][][4]float64, len(d.Matrix))
+	for i := range rows {
+		rows[i] = make([][4]float64, width)
+	}
+
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			column := x - bounds.Min.X
+			wanted := addError(m.At(x, y), rows[0][column])
+			index := indexer.Index(wanted)
+			dst.SetColorIndex(x, y, index)
+
+			quantErr := colorError(wanted, modelColor(model, index))
+			for dy, row := range d.Matrix {
+				for dx, weight := range row {
+					neighbor := column + dx - d.Offset
+					if weight == 0 || (dy == 0 && dx <= d.Offset) || neighbor < 0 || neighbor >= width {
+						continue
+					}
+					for c := range quantErr {
+						rows[dy][neighbor][c] += quantErr[c] * weight / d.Divisor
+					}
+				}
+			}
+		}
+		// NOTE Move the errors for the current row to the end, and reuse them
+		// for a new row.
+		current := rows[0]
+		copy(rows, rows[1:])
+		for i := range current {
+			current[i] = [4]float64{}
+		}
+		rows[len(rows)-1] = current
+	}
+	return dst
+}
+
+// ModelColor returns the color at the index, or no color if the index is not
+// in the model.
+func modelColor(model ColorModel, index uint8) color.Color {
+	if int(index) >= len(model) {
+		return noColor
+	}
+	return model[index]
+}
+
+// AddError adds the channel errors to a color, clamping the result so that it
+// is a valid alpha-premultiplied color.
+func addError(c color.Color, channelErr [4]float64) color.RGBA64 {
+	r, g, b, a := c.RGBA()
+	alpha := clampChannel(float64(a)+channelErr[3], 0xFFFF)
+	return color.RGBA64{
+		R: clampChannel(float64(r)+channelErr[0], alpha),
+		G: clampChannel(float64(g)+channelErr[1], alpha),
+		B: clampChannel(float64(b)+channelErr[2], alpha),
+		A: alpha,
+	}
+}
+
+// ColorError returns the difference of each channel of the wanted and actual
+// colors.
+func colorError(wanted, actual color.Color) [4]float64 {
+	wr, wg, wb, wa := wanted.RGBA()
+	ar, ag, ab, aa := actual.RGBA()
+	return [4]float64{
+		float64(wr) - float64(ar),
+		float64(wg) - float64(ag),
+		float64(wb) - float64(ab),
+		float64(wa) - float64(aa),
+	}
+}
+
+// ClampChannel rounds a channel value and clamps it within [0, max].
+func clampChannel(v float64, max uint16) uint16 {
+	switch {
+	case v <= 0:
+		return 0
+	case v >= float64(max):
+		return max
+	}
+	return uint16(v + 0.5)
+}
+
+// OrderedDither is a Ditherer that offsets the color of each pixel by a value
+// from a threshold map that is tiled over the image. Because every pixel is
+// quantized on its own, the result is stable between frames of an animation
+// and can be computed in parallel.
+type OrderedDither struct {
+	// Matrix is the threshold map. Each threshold ranges within [0, 1). Empty
+	// rows, and an empty matrix, do not offset the pixels.
+	Matrix [][]float64
+	// Spread is how much a threshold can offset a color channel, ranging
+	// within [0, 1]. It should be about the distance between the levels of a
+	// channel in the model. If it is 0, a spread based on the model's pixel
+	// mode is used.
+	Spread float64
+}
+
+// Bayer matrices for ordered dithering.
+var (
+	Bayer2x2 = OrderedDither{Matrix: bayerMatrix(2)}
+	Bayer4x4 = OrderedDither{Matrix: bayerMatrix(4)}
+	Bayer8x8 = OrderedDither{Matrix: bayerMatrix(8)}
+)
+
+// NewOrderedDither creates an ordered Ditherer that uses the brightness of
+// each pixel of m as its threshold map. This can be used to dither with a blue
+// noise texture.
+func NewOrderedDither(m image.Image) OrderedDither {
+	bounds := m.Bounds()
+	matrix := make([][]float64, bounds.Dy())
+	for y := range matrix {
+		matrix[y] = make([]float64, bounds.Dx())
+		for x := range matrix[y] {
+			gray := color.Gray16Model.Convert(m.At(bounds.Min.X+x, bounds.Min.Y+y)).(color.Gray16)
+			matrix[y][x] = float64(gray.Y) / (0xFFFF + 1)
+		}
+	}
+	return OrderedDither{Matrix: matrix}
+}
+
+// Dither offsets each pixel by its threshold, and then matches it to the
+// model.
+func (d OrderedDither) Dither(m image.Image, model ColorModel, distance ColorDistance) image.PalettedImage {
+	indexer := newColorIndexer(model, distance)
+	bounds := m.Bounds()
+	dst := image.NewPaletted(bounds, color.Palette(model))
+	spread := d.Spread
+	if spread == 0 {
+		spread = defaultSpread(model.PixelMode())
+	}
+	if len(d.Matrix) == 0 {
+		spread = 0
+	}
+
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			var offset float64
+			if spread != 0 {
+				if row := d.Matrix[modulo(y, len(d.Matrix))]; len(row) != 0 {
+					offset = (row[modulo(x, len(row))] - 0.5) * spread * 0xFFFF
+				}
+			}
+			c := addError(m.At(x, y), [4]float64{offset, offset, offset, 0})
+			dst.SetColorIndex(x, y, indexer.Index(c))
+		}
+	}
+	return dst
+}
+
+// DitherDrawer is a draw.Drawer that dithers the source image to the colors of
+// a model, so that images can be dithered as their own step before they are
+// encoded.
+type DitherDrawer struct {
+	// Model is the colors the source is dithered to. If it is nil, the palette
+	// of the destination is used.
+	Model ColorModel
+	// Distance is used to match colors, like the distance of Ditherer.Dither.
+	Distance ColorDistance
+	// Ditherer maps the pixels to the model. If it is nil, each pixel is
+	// matched to the model without dithering.
+	Ditherer Ditherer
+}
+
+// Draw dithers the part of src that starts at sp and aligns with r.Min to the
+// model, and draws it to r of dst. If dst has the same palette as the model,
+// the indices are set directly. If there is no model, src is drawn without
+// changes.
+func (d DitherDrawer) Draw(dst draw.Image, r image.Rectangle, src image.Image, sp image.Point) {
+	model := d.Model
+	if model == nil {
+		model = paletteModel(dst.ColorModel())
+	}
+	if model == nil {
+		draw.Draw(dst, r, src, sp, draw.Src)
+		return
+	}
+	ditherer := d.Ditherer
+	if ditherer == nil {
+		ditherer = OrderedDither{}
+	}
+
+	// NOTE Clip the rectangle to both images, like draw.Draw.
+	min := r.Min
+	r = r.Intersect(dst.Bounds()).Intersect(src.Bounds().Add(min.Sub(sp)))
+	if r.Empty() {
+		return
+	}
+	sp = sp.Add(r.Min.Sub(min))
+	delta := r.Min.Sub(sp)
+	area := image.Rectangle{sp, sp.Add(r.Size())}
+
+	dithered := ditherer.Dither(boundedImage{src, area}, model, d.Distance)
+	indexed, setIndex := dst.(interface{ SetColorIndex(x, y int, index uint8) })
+	setIndex = setIndex && samePalette(color.Palette(paletteModel(dst.ColorModel())), color.Palette(model))
+	for y := area.Min.Y; y < area.Max.Y; y++ {
+		for x := area.Min.X; x < area.Max.X; x++ {
+			index := dithered.ColorIndexAt(x, y)
+			if setIndex {
+				indexed.SetColorIndex(x+delta.X, y+delta.Y, index)
+			} else {
+				dst.Set(x+delta.X, y+delta.Y, modelColor(model, index))
+			}
+		}
+	}
+}
+
+// DitherImage dithers m to the colors of the model, and returns the result as
+// an imretro image with the same bounds as m.
+func DitherImage(m image.Image, model ColorModel, distance ColorDistance, d Ditherer) Image {
+	dst := New(m.Bounds(), model)
+	DitherDrawer{Distance: distance, Ditherer: d}.Draw(dst, dst.Bounds(), m, m.Bounds().Min)
+	return dst
+}
+
+// BoundedImage is an image whose bounds are limited to a part of another
+// image.
+type boundedImage struct {
+	image.Image
+	bounds image.Rectangle
+}
+
+// Bounds returns the limited bounds.
+func (m boundedImage) Bounds() image.Rectangle {
+	return m.bounds
+}
+
+// BayerMatrix creates a size-by-size Bayer threshold map. Size must be a power
+// of 2.
+func bayerMatrix(size int) [][]float64 {
+	indices := [][]int{{0}}
+	for n := 1; n < size; n *= 2 {
+		next := make([][]int, n*2)
+		for y := range next {
+			next[y] = make([]int, n*2)
+			for x := range next[y] {
+				quadrant := [2][2]int{{0, 2}, {3, 1}}[y/n][x/n]
+				next[y][x] = 4*indices[y%n][x%n] + quadrant
+			}
+		}
+		indices = next
+	}
+
+	matrix := make([][]float64, size)
+	for y := range matrix {
+		matrix[y] = make([]float64, size)
+		for x, index := range indices[y] {
+			matrix[y][x] = (float64(index) + 0.5) / float64(size*size)
+		}
+	}
+	return matrix
+}
+
+// DefaultSpread returns the distance between the levels of a color channel in
+// the default model for the pixel mode.
+func defaultSpread(mode PixelMode) float64 {
+	if mode == OneBit {
+		return 1
+	}
+	return 1.0 / 3.0
+}
+
+// Modulo is like %, but is never negative.
+func modulo(a, b int) int {
+	return ((a % b) + b) % b
+}
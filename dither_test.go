@@ -0,0 +1,263 @@
+package imretro
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/draw"
+	"math"
+	"testing"
+)
+
+// TestErrorDiffusionAverage tests that each kernel keeps the average brightness
+// of a solid gray image.
+func TestErrorDiffusionAverage(t *testing.T) {
+	kernels := map[string]ErrorDiffusion{
+		"Floyd-Steinberg":     FloydSteinberg,
+		"Atkinson":            Atkinson,
+		"Jarvis-Judice-Ninke": JarvisJudiceNinke,
+		"Stucki":              Stucki,
+		"Burkes":              Burkes,
+		"Sierra":              Sierra,
+	}
+	m := image.NewGray(image.Rect(0, 0, 32, 32))
+	draw.Draw(m, m.Bounds(), image.NewUniform(mediumGray), image.Point{}, draw.Src)
+
+	for name, kernel := range kernels {
+		t.Logf(`Testing %s`, name)
+		dithered := kernel.Dither(m, Default1BitColorModel, EuclideanDistance)
+		var on int
+		for y := 0; y < 32; y++ {
+			for x := 0; x < 32; x++ {
+				on += int(dithered.ColorIndexAt(x, y))
+			}
+		}
+		if ratio := float64(on) / (32 * 32); math.Abs(ratio-0.5) > 0.05 {
+			t.Errorf(`%v of pixels are on, want about 0.5`, ratio)
+		}
+	}
+}
+
+// TestErrorDiffusionExactColors tests that colors that are in the model are
+// not changed by dithering.
+func TestErrorDiffusionExactColors(t *testing.T) {
+	green := color.RGBA{0, 0xFF, 0, 0xFF}
+	model := ColorModel{black, green}
+	m := image.NewRGBA(image.Rect(2, 3, 10, 11))
+	for y := 3; y < 11; y++ {
+		for x := 2; x < 10; x++ {
+			if (x+y)%3 == 0 {
+				m.Set(x, y, green)
+			} else {
+				m.Set(x, y, black)
+			}
+		}
+	}
+
+	dithered := FloydSteinberg.Dither(m, model, nil)
+	if bounds := dithered.Bounds(); bounds != m.Bounds() {
+		t.Fatalf(`bounds = %v, want %v`, bounds, m.Bounds())
+	}
+	for y := 3; y < 11; y++ {
+		for x := 2; x < 10; x++ {
+			var want uint8
+			if (x+y)%3 == 0 {
+				want = 1
+			}
+			if index := dithered.ColorIndexAt(x, y); index != want {
+				t.Errorf(`index at (%d, %d) = %d, want %d`, x, y, index, want)
+			}
+		}
+	}
+}
+
+// TestEncodeDithered tests that an image can be dithered while encoding.
+func TestEncodeDithered(t *testing.T) {
+	m := image.NewGray(image.Rect(0, 0, 4, 4))
+	draw.Draw(m, m.Bounds(), image.NewUniform(mediumGray), image.Point{}, draw.Src)
+
+	var b bytes.Buffer
+	o := EncodeOptions{PixelMode: OneBit, Distance: EuclideanDistance, Dither: FloydSteinberg}
+	if err := EncodeWithOptions(&b, m, o); err != nil {
+		t.Fatalf(`err = %v, want nil`, err)
+	}
+
+	t.Log("Skipping to pixels")
+	b.Next(11 + 8)
+	FailByteHelper(t, &b, 0b1010_0101)
+	FailByteHelper(t, &b, 0b1010_0101)
+}
+
+// TestBayerMatrix tests that Bayer matrices are generated with evenly
+// distributed thresholds.
+func TestBayerMatrix(t *testing.T) {
+	want := [][]float64{{0.5 / 4, 2.5 / 4}, {3.5 / 4, 1.5 / 4}}
+	for y, row := range bayerMatrix(2) {
+		for x, actual := range row {
+			if actual != want[y][x] {
+				t.Errorf(`threshold at (%d, %d) = %v, want %v`, x, y, actual, want[y][x])
+			}
+		}
+	}
+
+	for _, size := range []int{4, 8} {
+		seen := make(map[float64]bool)
+		for _, row := range bayerMatrix(size) {
+			for _, threshold := range row {
+				seen[threshold] = true
+			}
+		}
+		if l := len(seen); l != size*size {
+			t.Errorf(`%d unique thresholds for %dx%d matrix, want %d`, l, size, size, size*size)
+		}
+	}
+}
+
+// TestOrderedDither tests that ordered dithering keeps the average brightness
+// of a solid gray image, and that it repeats the threshold map.
+func TestOrderedDither(t *testing.T) {
+	m := image.NewGray(image.Rect(0, 0, 8, 8))
+	draw.Draw(m, m.Bounds(), image.NewUniform(mediumGray), image.Point{}, draw.Src)
+
+	dithered := Bayer4x4.Dither(m, Default1BitColorModel, nil)
+	var on int
+	for y := 0; y < 8; y++ {
+		for x := 0; x < 8; x++ {
+			index := dithered.ColorIndexAt(x, y)
+			on += int(index)
+			if tiled := dithered.ColorIndexAt(x%4, y%4); index != tiled {
+				t.Errorf(`index at (%d, %d) = %d, want %d`, x, y, index, tiled)
+			}
+		}
+	}
+	if on != 32 {
+		t.Errorf(`%d pixels are on, want 32`, on)
+	}
+}
+
+// TestOrderedDitherThresholdMap tests that a custom threshold map can be made
+// from an image.
+func TestOrderedDitherThresholdMap(t *testing.T) {
+	noise := image.NewGray(image.Rect(0, 0, 2, 1))
+	noise.SetGray(0, 0, color.Gray{0x20})
+	noise.SetGray(1, 0, color.Gray{0xE0})
+	d := NewOrderedDither(noise)
+
+	m := image.NewGray(image.Rect(0, 0, 4, 2))
+	draw.Draw(m, m.Bounds(), image.NewUniform(mediumGray), image.Point{}, draw.Src)
+	dithered := d.Dither(m, Default1BitColorModel, nil)
+
+	for y := 0; y < 2; y++ {
+		for x := 0; x < 4; x++ {
+			want := uint8(x % 2)
+			if index := dithered.ColorIndexAt(x, y); index != want {
+				t.Errorf(`index at (%d, %d) = %d, want %d`, x, y, index, want)
+			}
+		}
+	}
+}
+
+// TestDitherImage tests that dithering can output an imretro image.
+func TestDitherImage(t *testing.T) {
+	m := image.NewGray(image.Rect(10, 10, 12, 12))
+	draw.Draw(m, m.Bounds(), image.NewUniform(mediumGray), image.Point{10, 10}, draw.Src)
+	green := color.RGBA{0, 0xFF, 0, 0xFF}
+
+	i := DitherImage(m, ColorModel{black, green, white}, EuclideanDistance, Bayer2x2)
+
+	if bounds, want := i.Bounds(), m.Bounds(); bounds != want {
+		t.Fatalf(`bounds = %v, want %v`, bounds, want)
+	}
+	if mode := i.PixelMode(); mode != TwoBit {
+		t.Errorf(`pixel mode = %08b, want %08b`, mode, TwoBit)
+	}
+	if l := len(i.Palette()); l != 4 {
+		t.Errorf(`palette length = %d, want 4`, l)
+	}
+	CompareColors(t, i.At(10, 10), black)
+	CompareColors(t, i.At(11, 10), white)
+	CompareColors(t, i.At(10, 11), white)
+	CompareColors(t, i.At(11, 11), black)
+}
+
+// TestDitherDrawer tests that the dithered source is drawn to the part of the
+// destination at r, with indices for paletted destinations and with colors
+// for others.
+func TestDitherDrawer(t *testing.T) {
+	src := image.NewGray(image.Rect(10, 10, 14, 14))
+	draw.Draw(src, src.Bounds(), image.NewUniform(mediumGray), image.Point{}, draw.Src)
+	model := ColorModel{black, white}
+	want := DitherImage(src, model, EuclideanDistance, Bayer2x2)
+	drawer := DitherDrawer{Model: model, Distance: EuclideanDistance, Ditherer: Bayer2x2}
+	r := image.Rect(1, 1, 10, 10)
+	sp := image.Pt(11, 11)
+
+	rgba := image.NewRGBA(image.Rect(0, 0, 4, 4))
+	draw.Draw(rgba, rgba.Bounds(), image.NewUniform(color.RGBA{0xFF, 0, 0, 0xFF}), image.Point{}, draw.Src)
+	paletted := image.NewPaletted(image.Rect(0, 0, 4, 4), color.Palette(model))
+	for _, dst := range []draw.Image{rgba, paletted} {
+		drawer.Draw(dst, r, src, sp)
+		for y := 0; y < 4; y++ {
+			for x := 0; x < 4; x++ {
+				if x == 0 || y == 0 {
+					CompareColors(t, dst.At(x, y), dst.At(0, 0))
+					continue
+				}
+				CompareColors(t, dst.At(x, y), want.At(x+10, y+10))
+			}
+		}
+	}
+	if index := paletted.ColorIndexAt(1, 1); index != want.ColorIndexAt(11, 11) {
+		t.Errorf(`index = %d, want %d`, index, want.ColorIndexAt(11, 11))
+	}
+}
+
+// TestDitherDrawerDefaults tests that the palette of the destination is used
+// without a model, and that pixels are matched without a Ditherer.
+func TestDitherDrawerDefaults(t *testing.T) {
+	src := image.NewGray(image.Rect(0, 0, 2, 1))
+	src.SetGray(1, 0, color.Gray{0xF0})
+	dst := New(src.Bounds(), Default1BitColorModel)
+	DitherDrawer{}.Draw(dst, dst.Bounds(), src, image.Point{})
+	for x, want := range []uint8{0, 1} {
+		if index := dst.ColorIndexAt(x, 0); index != want {
+			t.Errorf(`index at (%d, 0) = %d, want %d`, x, index, want)
+		}
+	}
+}
+
+// TestErrorDiffusionWithoutKernel tests that an empty kernel or a divisor of 0
+// only matches the pixels to the model.
+func TestErrorDiffusionWithoutKernel(t *testing.T) {
+	m := image.NewGray(image.Rect(0, 0, 3, 2))
+	for i := range m.Pix {
+		m.Pix[i] = 0x60
+	}
+	for _, d := range []ErrorDiffusion{{}, {Matrix: FloydSteinberg.Matrix, Offset: 1}} {
+		dithered := d.Dither(m, Default1BitColorModel, nil)
+		for y := 0; y < 2; y++ {
+			for x := 0; x < 3; x++ {
+				if actual := dithered.ColorIndexAt(x, y); actual != 0 {
+					t.Errorf(`index at (%d, %d) = %d, want 0`, x, y, actual)
+				}
+			}
+		}
+	}
+}
+
+// TestOrderedDitherEmptyRows tests that empty rows of the threshold map do not
+// offset the pixels.
+func TestOrderedDitherEmptyRows(t *testing.T) {
+	m := image.NewGray(image.Rect(0, 0, 2, 2))
+	for i := range m.Pix {
+		m.Pix[i] = 0x60
+	}
+	for _, d := range []OrderedDither{{}, {Matrix: [][]float64{{}}}, {Matrix: [][]float64{{}, {0.99}}}} {
+		dithered := d.Dither(m, Default1BitColorModel, nil)
+		for x := 0; x < 2; x++ {
+			if actual := dithered.ColorIndexAt(x, 0); actual != 0 {
+				t.Errorf(`index at (%d, 0) = %d, want 0`, x, actual)
+			}
+		}
+	}
+}
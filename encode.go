@@ -1,6 +1,7 @@
 package imretro
 
 import (
+	"errors"
 	"image"
 	"image/color"
 	"io"
@@ -10,61 +11,397 @@ import (
 	"github.com/imretro/go/internal/util"
 )
 
-// Encode writes the image m to w in imretro format.
+// EncodeOptions are the options used by EncodeWithOptions.
+type EncodeOptions struct {
+	// PixelMode is the number of bits each pixel will have. It is ignored if
+	// Model is set.
+	PixelMode PixelMode
+	// Model is the color model that will be written as the in-file palette,
+	// and that the pixels will be indexed against. If Model is nil, the
+	// default color model for the PixelMode will be used.
+	//
+	// Pixels are matched to the closest color of Model according to
+	// Distance, or EuclideanDistance if Distance is not set, so that colors
+	// of the model are kept.
+	Model ColorModel
+	// PaletteFormat is the layout of the in-file palette, using the same bits
+	// as the mode byte: WithPalette, unioned with a color channel flag
+	// (Grayscale, RGB, or RGBA) and, optionally, EightBitColors. Without
+	// EightBitColors, each color channel is stored in 2 bits. If it is zero,
+	// DefaultPaletteFormat is used.
+	PaletteFormat ModeFlag
+	// NoPalette omits the in-file palette, so that only the pixel mode is
+	// recorded. The image should then be decoded with a known color model. The
+	// PaletteFormat is ignored when this is set.
+	NoPalette bool
+	// Distance, if set, matches each pixel to the closest color in the model
+	// according to this distance function. If it is not set, the default
+	// models use the thresholds of ColorModel.Index. EuclideanDistance is the cheapest choice, while
+	// distances like OKLabDistance and CIEDE2000Distance give matches that
+	// look closer.
+	Distance ColorDistance
+	// Dither, if set, is used to map the pixels to the model's colors, so
+	// that gradients are approximated with patterns of the available colors.
+	// Colors are matched according to Distance.
+	Dither Ditherer
+	// AutoPixelMode uses the distinct colors of the image as the model, which
+	// picks the smallest pixel mode that can encode the image without losing
+	// any colors. If the image has more than 256 colors, Model and PixelMode
+	// are used instead.
+	AutoPixelMode bool
+	// AdaptivePalette, if set, creates the model from the colors of the image
+	// with the quantizer, using PixelMode for the number of colors. Pixels
+	// are matched to the closest color according to Distance, or
+	// EuclideanDistance if Distance is not set. If AutoPixelMode is also set,
+	// the adaptive palette is only used if the image has too many colors.
+	AdaptivePalette Quantizer
+}
+
+var (
+	// ErrRowLength is returned when a row written to an Encoder does not have
+	// the width of the image.
+	ErrRowLength = errors.New("Row length does not match the image width")
+	// ErrTooManyRows is returned when more rows than the height of the image
+	// are written to an Encoder.
+	ErrTooManyRows = errors.New("All rows of the image have been written")
+	// ErrMissingRows is returned when an Encoder is closed before all rows of
+	// the image have been written.
+	ErrMissingRows = errors.New("Not all rows of the image have been written")
+	// ErrNegativeDimension is returned when encoding an image with a negative
+	// width or height.
+	ErrNegativeDimension = errors.New("Dimensions cannot be negative")
+)
+
+// DefaultPaletteFormat is the palette layout used when the PaletteFormat
+// option is not set.
+const DefaultPaletteFormat = WithPalette | RGBA | EightBitColors
+
+// Encode writes the image m to w in imretro format, using the default color
+// model for the pixel mode. Paletted images are written with their own palette
+// instead. See EncodeWithOptions for details.
 func Encode(w io.Writer, m image.Image, pixelMode PixelMode) error {
-	var helper encoderHelper
-	switch pixelMode {
-	case OneBit:
-		helper = encodeOneBit
-	case TwoBit:
-		helper = encodeTwoBit
-	case EightBit:
-		helper = encodeEightBit
-	default:
-		return UnsupportedBitModeError(pixelMode)
-	}
+	return EncodeWithOptions(w, m, EncodeOptions{PixelMode: pixelMode})
+}
 
-	if _, err := w.Write([]byte("IMRETRO")); err != nil {
-		return err
+// EncodeWithOptions writes the image m to w in imretro format.
+//
+// The pixel mode is picked from the length of the color model. If the model
+// has fewer colors than the pixel mode supports, the in-file palette will be
+// padded with transparent colors.
+//
+// If no model, Dither, or AdaptivePalette is set and m is an
+// image.PalettedImage, such as an *image.Paletted or an Image, with at most
+// 256 colors, its palette is used as the model and its color indices are
+// written without changes, unless AutoPixelMode finds a smaller pixel mode for
+// its colors. An Image from Decode is then also written with the
+// palette format it was decoded from, unless the PaletteFormat or NoPalette
+// options are set, so that it is encoded to the same bytes.
+func EncodeWithOptions(w io.Writer, m image.Image, o EncodeOptions) error {
+	o.Distance = modelDistance(o)
+	model := o.Model
+	var exact colorIndex
+	var paletted image.PalettedImage
+	if model == nil && o.Dither == nil && o.AdaptivePalette == nil {
+		paletted, model = palettedSource(m)
+	}
+	if o.AutoPixelMode {
+		// NOTE A paletted image is only re-indexed if its colors fit a
+		// smaller pixel mode than its palette.
+		imageModel, index, ok := exactModel(m)
+		if ok && (paletted == nil || imageModel.PixelMode() < model.PixelMode()) {
+			paletted, model, exact = nil, imageModel, index
+		}
+	}
+	// NOTE The palette layout of a decoded image only fits its own palette.
+	if i, ok := m.(imretroImage); ok && paletted != nil && o.PaletteFormat == 0 && !o.NoPalette {
+		o.PaletteFormat, o.NoPalette = i.paletteFormat, i.noPalette
 	}
-	if _, err := w.Write([]byte{pixelMode | WithPalette | RGBA | EightBitColors}); err != nil {
+	if model == nil && o.AdaptivePalette != nil {
+		var err error
+		if model, err = AdaptiveModel(m, o.PixelMode, o.AdaptivePalette); err != nil {
+			return err
+		}
+		if o.Distance == nil {
+			o.Distance = EuclideanDistance
+		}
+	}
+	bounds := m.Bounds()
+	model, err := encodeHeader(w, model, bounds.Dx(), bounds.Dy(), o)
+	if err != nil {
 		return err
 	}
+	var pixels image.PalettedImage = indexedImage{m, newColorIndexer(model, o.Distance)}
+	switch {
+	case paletted != nil:
+		pixels = paletted
+	case exact != nil:
+		pixels = indexedImage{m, exact}
+	case o.Dither != nil:
+		pixels = o.Dither.Dither(m, model, o.Distance)
+	}
+	return encodePixels(w, pixels, model.PixelMode())
+}
 
-	bounds := m.Bounds()
-	width, height := bounds.Dx(), bounds.Dy()
+// EncodeHeader writes the signature, mode byte, dimensions, and palette. If the
+// model is nil, the default model for the pixel mode of the options is used,
+// and the model that was written is returned.
+func encodeHeader(w io.Writer, model ColorModel, width, height int, o EncodeOptions) (ColorModel, error) {
+	if model == nil {
+		if !IsBitCountSupported(o.PixelMode) {
+			return nil, UnsupportedBitModeError(o.PixelMode)
+		}
+		model = DefaultModelMap[o.PixelMode].(ColorModel)
+	}
+	if len(model) > 1<<8 {
+		return nil, ErrUnknownModel
+	}
+	var paletteFormat ModeFlag
+	var paletteChannels int
+	if !o.NoPalette {
+		paletteFormat = o.PaletteFormat
+		if paletteFormat == 0 {
+			paletteFormat = DefaultPaletteFormat
+		}
+		if paletteFormat&^paletteFormatBits != 0 || paletteFormat&WithPalette == 0 {
+			return nil, UnsupportedPaletteFormatError(paletteFormat)
+		}
+		var ok bool
+		paletteChannels, ok = channelCount(paletteFormat & (0b11 << colorChannelIndex))
+		if !ok {
+			return nil, UnsupportedPaletteFormatError(paletteFormat)
+		}
+	}
+	pixelMode := model.PixelMode()
+
+	if _, err := w.Write([]byte(ImretroSignature)); err != nil {
+		return nil, err
+	}
+	if _, err := w.Write([]byte{pixelMode | paletteFormat}); err != nil {
+		return nil, err
+	}
+	if err := encodeDimensions(w, width, height); err != nil {
+		return nil, err
+	}
+
+	if !o.NoPalette {
+		accurateColors := paletteFormat&EightBitColors != 0
+		if err := writePalette(w, model, pixelMode, paletteChannels, accurateColors); err != nil {
+			return nil, err
+		}
+	}
+	return model, nil
+}
+
+// Encoder writes an imretro image one row at a time, so that images can be
+// encoded without holding all of their pixels.
+type Encoder struct {
+	w       io.Writer
+	indexer colorIndexer
+	width   int
+	height  int
+	// BitsPerPixel is the number of bits written for each index.
+	bitsPerPixel int
+	// Y is the number of rows that have been written.
+	y int
+	// Pending holds the bits of the last row that did not fill a byte, and
+	// pendingBits is the number of those bits.
+	pending     byte
+	pendingBits int
+	buffer      []byte
+	indices     []uint8
+}
+
+// NewEncoder writes the header and palette of an image with the dimensions to
+// w, and returns an Encoder for its pixels. The options are used like
+// EncodeWithOptions, except that AutoPixelMode, AdaptivePalette, and Dither
+// are ignored because they need the whole image.
+func NewEncoder(w io.Writer, width, height int, o EncodeOptions) (*Encoder, error) {
+	model, err := encodeHeader(w, o.Model, width, height, o)
+	if err != nil {
+		return nil, err
+	}
+	return &Encoder{
+		w:            w,
+		indexer:      newColorIndexer(model, modelDistance(o)),
+		width:        width,
+		height:       height,
+		bitsPerPixel: model.padded(model.PixelMode()).BitsPerPixel(),
+		indices:      make([]uint8, width),
+	}, nil
+}
+
+// WriteIndices writes the next row of pixels as palette indices. Only the low
+// bits of each index that the pixel mode uses are written.
+func (e *Encoder) WriteIndices(row []uint8) error {
+	if len(row) != e.width {
+		return ErrRowLength
+	}
+	if e.y >= e.height {
+		return ErrTooManyRows
+	}
+	bits := e.pendingBits + e.width*e.bitsPerPixel
+	size := (bits + 7) / 8
+	if cap(e.buffer) < size {
+		e.buffer = make([]byte, size)
+	}
+	buffer := e.buffer[:size]
+	for i := range buffer {
+		buffer[i] = 0
+	}
+	if size > 0 {
+		buffer[0] = e.pending
+	}
+
+	mask := byte(0xFF >> (8 - e.bitsPerPixel))
+	for x, index := range row {
+		offset := e.pendingBits + x*e.bitsPerPixel
+		shift := 8 - e.bitsPerPixel - offset%8
+		buffer[offset/8] |= index & mask << shift
+	}
+	e.pendingBits = bits % 8
+	if e.pendingBits != 0 {
+		size--
+		e.pending = buffer[size]
+	}
+	e.y++
+	_, err := e.w.Write(buffer[:size])
+	return err
+}
+
+// WriteColors writes the next row of pixels, indexing each color against the
+// model.
+func (e *Encoder) WriteColors(row []color.Color) error {
+	if len(row) != e.width {
+		return ErrRowLength
+	}
+	for x, c := range row {
+		e.indices[x] = e.indexer.Index(c)
+	}
+	return e.WriteIndices(e.indices)
+}
+
+// Close writes the bits of the last row that did not fill a byte. It returns
+// ErrMissingRows if fewer rows than the height have been written.
+func (e *Encoder) Close() error {
+	if e.pendingBits != 0 {
+		if _, err := e.w.Write([]byte{e.pending}); err != nil {
+			return err
+		}
+		e.pendingBits = 0
+	}
+	if e.y < e.height {
+		return ErrMissingRows
+	}
+	return nil
+}
+
+// PalettedSource returns the image and its palette as a model if the image
+// is paletted with at most 256 colors, so that its indices can be written
+// verbatim.
+func palettedSource(m image.Image) (image.PalettedImage, ColorModel) {
+	paletted, ok := m.(image.PalettedImage)
+	if !ok {
+		return nil, nil
+	}
+	model := paletteModel(paletted.ColorModel())
+	if model == nil || len(model) > 1<<8 {
+		return nil, nil
+	}
+	return paletted, model
+}
+
+// PaletteModel returns the color model as a ColorModel if it is a ColorModel or
+// a color.Palette, and nil otherwise.
+func paletteModel(m color.Model) ColorModel {
+	switch p := m.(type) {
+	case ColorModel:
+		return p
+	case color.Palette:
+		return ColorModel(p)
+	}
+	return nil
+}
+
+// EncodeDimensions writes the width and height as 2 12-bit numbers.
+func encodeDimensions(w io.Writer, width, height int) error {
 	for _, d := range []int{width, height} {
+		if d < 0 {
+			return ErrNegativeDimension
+		}
 		if d > MaximumDimension {
 			return DimensionsTooLargeError(d)
 		}
 	}
 	dimensions := uint(width<<12 | height)
-	{
-		writer := bitio.NewWriter(w, 3)
-		if _, err := writer.WriteBits(dimensions, 24); err != nil {
-			return err
-		}
+	writer := bitio.NewWriter(w, 3)
+	_, err := writer.WriteBits(dimensions, 24)
+	return err
+}
+
+// ColorIndexer finds the index of a color in a palette.
+type colorIndexer interface {
+	Index(color.Color) uint8
+}
+
+// ModelDistance returns the distance of the options, or EuclideanDistance if
+// the options have a model but no distance. The thresholds of
+// ColorModel.Index only fit the default models.
+func modelDistance(o EncodeOptions) ColorDistance {
+	if o.Distance == nil && o.Model != nil {
+		return EuclideanDistance
 	}
+	return o.Distance
+}
 
-	if err := writePalette(w, DefaultModelMap[pixelMode].(ColorModel)); err != nil {
-		return err
+// NewColorIndexer returns the model itself, or a NearestModel for the model if
+// the distance is set.
+func newColorIndexer(model ColorModel, distance ColorDistance) colorIndexer {
+	if distance == nil {
+		return model
 	}
-	return helper(w, m)
+	return NearestModel{model, distance}
+}
+
+// IndexedImage wraps an image so that each of its pixels is indexed against a
+// palette.
+type indexedImage struct {
+	image.Image
+	indexer colorIndexer
+}
+
+// ColorIndexAt returns the palette index for the pixel.
+func (m indexedImage) ColorIndexAt(x, y int) uint8 {
+	return m.indexer.Index(m.At(x, y))
 }
 
 // EncoderHelper is a unifying type for the specialized pixel encoding
 // functions.
-type encoderHelper = func(io.Writer, image.Image) error
+type encoderHelper = func(io.Writer, image.PalettedImage) error
 
-func encodeOneBit(w io.Writer, m image.Image) error {
+// EncodePixels writes the packed palette indices of the pixels for the pixel
+// mode.
+func encodePixels(w io.Writer, m image.PalettedImage, pixelMode PixelMode) error {
+	var helper encoderHelper
+	switch pixelMode {
+	case OneBit:
+		helper = encodeOneBit
+	case TwoBit:
+		helper = encodeTwoBit
+	case EightBit:
+		helper = encodeEightBit
+	default:
+		return UnsupportedBitModeError(pixelMode)
+	}
+	return helper(w, m)
+}
+
+func encodeOneBit(w io.Writer, m image.PalettedImage) error {
 	// NOTE Write the pixels
 	bounds := m.Bounds()
 	pixels := bitio.NewWriter(w, 1)
 	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
 		for x := bounds.Min.X; x < bounds.Max.X; x++ {
-			c := m.At(x, y)
-			// NOTE If at least 1 color is bright and not transparent, it is bright
-			bit := bitio.Bit(Default1BitColorModel.Index(c))
+			bit := bitio.Bit(m.ColorIndexAt(x, y))
 			if _, err := pixels.WriteBit(bit); err != nil {
 				return err
 			}
@@ -74,14 +411,13 @@ func encodeOneBit(w io.Writer, m image.Image) error {
 	return err
 }
 
-func encodeTwoBit(w io.Writer, m image.Image) error {
+func encodeTwoBit(w io.Writer, m image.PalettedImage) error {
 	// NOTE Write the pixels
 	bounds := m.Bounds()
 	pixels := bitio.NewWriter(w, 1)
 	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
 		for x := bounds.Min.X; x < bounds.Max.X; x++ {
-			c := m.At(x, y)
-			bits := bitio.Bits(Default2BitColorModel.Index(c))
+			bits := bitio.Bits(m.ColorIndexAt(x, y))
 			if _, err := pixels.WriteBits(bits, 2); err != nil {
 				return err
 			}
@@ -91,34 +427,37 @@ func encodeTwoBit(w io.Writer, m image.Image) error {
 	return err
 }
 
-func encodeEightBit(w io.Writer, m image.Image) error {
+func encodeEightBit(w io.Writer, m image.PalettedImage) error {
 	bounds := m.Bounds()
 	buffer := make([]byte, 0, bounds.Dx()*bounds.Dy())
 	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
 		for x := bounds.Min.X; x < bounds.Max.X; x++ {
-			c := m.At(x, y)
-			bits := byte(Default8BitColorModel.Index(c))
-			buffer = append(buffer, bits)
+			buffer = append(buffer, m.ColorIndexAt(x, y))
 		}
 	}
-	w.Write(buffer)
-	return nil
-}
-
-// WriteColor writes a color as 4 bytes to a Writer.
-func writeColor(w io.Writer, c color.Color) error {
-	r, g, b, a := util.ColorAsBytes(c)
-	_, err := w.Write([]byte{r, g, b, a})
+	_, err := w.Write(buffer)
 	return err
 }
 
-// WritePalette writes all the colors of the palette, where each color is 4
-// bytes, to a Writer.
-func writePalette(w io.Writer, p ColorModel) error {
-	for _, c := range p {
-		if err := writeColor(w, c); err != nil {
-			return err
+// WritePalette writes all the colors of the palette to a Writer, packed the
+// same way that decodeModel unpacks them. If the palette has fewer colors than
+// the pixel mode needs, the remaining colors are written as transparent.
+func writePalette(w io.Writer, p ColorModel, pixelMode PixelMode, channelCount int, accurateColors bool) error {
+	chunkSize := 1
+	bitsPerChannel := 2
+	if accurateColors {
+		chunkSize = channelCount
+		bitsPerChannel = 8
+	}
+	writer := bitio.NewWriter(w, chunkSize)
+	for _, c := range p.padded(pixelMode) {
+		for _, channel := range newColorBytes(c, channelCount) {
+			bits := bitio.Bits(util.ShrinkByte(channel, byte(bitsPerChannel)))
+			if _, err := writer.WriteBits(bits, bitsPerChannel); err != nil {
+				return err
+			}
 		}
 	}
-	return nil
+	_, err := writer.CommitPending()
+	return err
 }
@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"image"
 	"image/color"
+	"image/color/palette"
 	"testing"
 )
 
@@ -139,7 +140,7 @@ func TestEncode1BitPixelsErr(t *testing.T) {
 	w := &cappedWriter{cap: 1}
 	// NOTE A 16-pixel "image"
 	m := image.Rect(0, 0, 16, 1)
-	if err := encodeOneBit(w, m); err == nil {
+	if err := encodeOneBit(w, indexedImage{m, Default1BitColorModel}); err == nil {
 		t.Fatalf(`err = nil`)
 	}
 }
@@ -184,7 +185,7 @@ func TestEncode2BitPixelsErr(t *testing.T) {
 	w := &cappedWriter{cap: 2}
 	// NOTE A 32-pixel "image"
 	m := image.Rect(0, 0, 16, 2)
-	if err := encodeTwoBit(w, m); err == nil {
+	if err := encodeTwoBit(w, indexedImage{m, Default2BitColorModel}); err == nil {
 		t.Fatalf(`err = nil`)
 	}
 
@@ -303,6 +304,607 @@ func TestEncodeUnsupportedMode(t *testing.T) {
 	}
 }
 
+// TestEncodeWithModel tests that a custom color model is written as the
+// palette, and that the image decodes with its colors intact.
+func TestEncodeWithModel(t *testing.T) {
+	green := color.RGBA{0, 0xFF, 0, 0xFF}
+	model := ColorModel{black, green}
+	m := image.NewRGBA(image.Rect(0, 0, 2, 1))
+	m.Set(0, 0, black)
+	m.Set(1, 0, green)
+
+	var b bytes.Buffer
+	if err := EncodeWithOptions(&b, m, EncodeOptions{Model: model}); err != nil {
+		t.Fatalf(`err = %v, want nil`, err)
+	}
+
+	decoded, err := Decode(&b, nil)
+	if err != nil {
+		t.Fatalf(`err = %v, want nil`, err)
+	}
+	if mode := decoded.PixelMode(); mode != OneBit {
+		t.Errorf(`pixel mode = %08b, want %08b`, mode, OneBit)
+	}
+	CompareColors(t, decoded.At(0, 0), black)
+	CompareColors(t, decoded.At(1, 0), green)
+}
+
+// TestEncodePaddedPalette tests that a model with fewer colors than the pixel
+// mode needs is padded with transparent colors.
+func TestEncodePaddedPalette(t *testing.T) {
+	model := ColorModel{black, darkGray, white}
+	m := image.NewRGBA(image.Rect(0, 0, 1, 1))
+
+	var b bytes.Buffer
+	if err := EncodeWithOptions(&b, m, EncodeOptions{Model: model}); err != nil {
+		t.Fatalf(`err = %v, want nil`, err)
+	}
+
+	t.Log("Skipping to last palette color")
+	b.Next(11 + 12)
+	for i := 0; i < 4; i++ {
+		FailByteHelper(t, &b, 0)
+	}
+}
+
+// TestEncodeModelTooLarge tests that a model with more colors than any pixel
+// mode supports cannot be encoded.
+func TestEncodeModelTooLarge(t *testing.T) {
+	var b bytes.Buffer
+	m := image.NewRGBA(image.Rect(0, 0, 1, 1))
+	model := make(ColorModel, 257)
+
+	if err := EncodeWithOptions(&b, m, EncodeOptions{Model: model}); err != ErrUnknownModel {
+		t.Fatalf(`err = %v, want %v`, err, ErrUnknownModel)
+	}
+}
+
+// TestEncodePaletteFormats tests that each palette format is written so that
+// the decoder reads back the same colors.
+func TestEncodePaletteFormats(t *testing.T) {
+	formats := []ModeFlag{Grayscale, RGB, RGBA}
+	model := ColorModel{black, darkGray, lightGray, white}
+	m := image.NewRGBA(image.Rect(0, 0, 1, 1))
+
+	for _, channels := range formats {
+		for _, accuracy := range []ModeFlag{0, EightBitColors} {
+			format := WithPalette | channels | accuracy
+			t.Logf(`Testing palette format %08b`, format)
+
+			var b bytes.Buffer
+			err := EncodeWithOptions(&b, m, EncodeOptions{Model: model, PaletteFormat: format})
+			if err != nil {
+				t.Fatalf(`err = %v, want nil`, err)
+			}
+			decoded, err := Decode(&b, nil)
+			if err != nil {
+				t.Fatalf(`err = %v, want nil`, err)
+			}
+			for i, c := range decoded.Palette() {
+				CompareColors(t, c, model[i])
+			}
+		}
+	}
+}
+
+// TestEncode2BitRGBPalette tests that 2-bit color channels are packed across
+// color boundaries.
+func TestEncode2BitRGBPalette(t *testing.T) {
+	model := ColorModel{
+		color.RGBA{0xFF, 0, 0, 0xFF},
+		color.RGBA{0, 0xFF, 0, 0xFF},
+		color.RGBA{0, 0, 0xFF, 0xFF},
+		color.RGBA{0xFF, 0xFF, 0, 0xFF},
+	}
+	m := image.NewRGBA(image.Rect(0, 0, 1, 1))
+
+	var b bytes.Buffer
+	err := EncodeWithOptions(&b, m, EncodeOptions{Model: model, PaletteFormat: WithPalette | RGB})
+	if err != nil {
+		t.Fatalf(`err = %v, want nil`, err)
+	}
+
+	if mode := b.Bytes()[7]; mode != TwoBit|WithPalette|RGB {
+		t.Errorf(`mode byte = %08b, want %08b`, mode, TwoBit|WithPalette|RGB)
+	}
+
+	t.Log("Skipping to palette")
+	b.Next(11)
+	FailByteHelper(t, &b, 0b110000_00)
+	FailByteHelper(t, &b, 0b1100_0000)
+	FailByteHelper(t, &b, 0b11_111100)
+	FailByteHelper(t, &b, 0)
+	if l := b.Len(); l != 0 {
+		t.Errorf(`%d bytes remaining, want 0`, l)
+	}
+}
+
+// TestEncodeUnsupportedPaletteFormat tests that invalid palette formats cannot
+// be encoded.
+func TestEncodeUnsupportedPaletteFormat(t *testing.T) {
+	m := image.NewRGBA(image.Rect(0, 0, 1, 1))
+	for _, format := range []ModeFlag{RGBA, WithPalette | 0b110, WithPalette | 0b1000} {
+		var b bytes.Buffer
+		want := UnsupportedPaletteFormatError(format)
+		if err := EncodeWithOptions(&b, m, EncodeOptions{PaletteFormat: format}); err != want {
+			t.Errorf(`err = %v, want %v`, err, want)
+		}
+		if l := b.Len(); l != 0 {
+			t.Errorf(`%d bytes written, want 0`, l)
+		}
+	}
+}
+
+// TestEncodeNoPalette tests that the palette can be omitted, and that the
+// image can be decoded with a known model.
+func TestEncodeNoPalette(t *testing.T) {
+	green := color.RGBA{0, 0xFF, 0, 0xFF}
+	model := ColorModel{black, green}
+	m := image.NewRGBA(image.Rect(0, 0, 3, 1))
+	m.Set(1, 0, green)
+
+	var b bytes.Buffer
+	err := EncodeWithOptions(&b, m, EncodeOptions{Model: model, NoPalette: true})
+	if err != nil {
+		t.Fatalf(`err = %v, want nil`, err)
+	}
+	if l, want := b.Len(), 12; l != want {
+		t.Fatalf(`%d bytes written, want %d`, l, want)
+	}
+	if mode := b.Bytes()[7]; mode != OneBit {
+		t.Errorf(`mode byte = %08b, want %08b`, mode, OneBit)
+	}
+
+	decoded, err := Decode(&b, model)
+	if err != nil {
+		t.Fatalf(`err = %v, want nil`, err)
+	}
+	CompareColors(t, decoded.At(0, 0), black)
+	CompareColors(t, decoded.At(1, 0), green)
+	CompareColors(t, decoded.At(2, 0), black)
+}
+
+// TestEncodeCustomModelColors tests that pixels with the colors of a custom
+// model keep their colors without a distance option.
+func TestEncodeCustomModelColors(t *testing.T) {
+	darkBlue := color.RGBA{0, 0, 0x60, 0xFF}
+	models := []ColorModel{{black, darkBlue}, make(ColorModel, 16)}
+	for i := range models[1] {
+		models[1][i] = color.RGBA{uint8(i * 16), uint8(0xFF - i*16), uint8(i * 8), 0xFF}
+	}
+	for _, model := range models {
+		m := image.NewRGBA(image.Rect(0, 0, len(model), 1))
+		for x, c := range model {
+			m.Set(x, 0, c)
+		}
+		var b bytes.Buffer
+		if err := EncodeWithOptions(&b, m, EncodeOptions{Model: model}); err != nil {
+			t.Fatalf(`err = %v, want nil`, err)
+		}
+		decoded, err := Decode(&b, nil)
+		if err != nil {
+			t.Fatalf(`err = %v, want nil`, err)
+		}
+		for x, want := range model {
+			CompareColors(t, decoded.At(x, 0), want)
+		}
+	}
+}
+
+// TestEncodeNearestColors tests that pixels can be matched to the closest
+// color of the model.
+func TestEncodeNearestColors(t *testing.T) {
+	green := color.RGBA{0, 0xFF, 0, 0xFF}
+	model := ColorModel{black, green}
+	m := image.NewRGBA(image.Rect(0, 0, 2, 1))
+	m.Set(0, 0, color.RGBA{0xFF, 0, 0, 0xFF})
+	m.Set(1, 0, color.RGBA{0x40, 0xF0, 0x40, 0xFF})
+
+	var b bytes.Buffer
+	o := EncodeOptions{Model: model, Distance: EuclideanDistance}
+	if err := EncodeWithOptions(&b, m, o); err != nil {
+		t.Fatalf(`err = %v, want nil`, err)
+	}
+
+	t.Log("Skipping to pixels")
+	b.Next(11 + 8)
+	FailByteHelper(t, &b, 0b0100_0000)
+}
+
+// TestEncodeAutoPixelMode tests that the pixel mode and palette are picked from
+// the colors of the image.
+func TestEncodeAutoPixelMode(t *testing.T) {
+	red := color.RGBA{0xFF, 0, 0, 0xFF}
+	blue := color.RGBA{0, 0, 0xFF, 0xFF}
+	tests := []struct {
+		colors []color.Color
+		want   PixelMode
+	}{
+		{[]color.Color{red}, OneBit},
+		{[]color.Color{red, blue}, OneBit},
+		{[]color.Color{red, blue, black}, TwoBit},
+		{[]color.Color{red, blue, black, white, darkGray}, EightBit},
+	}
+
+	for _, tt := range tests {
+		m := image.NewRGBA(image.Rect(0, 0, len(tt.colors), 2))
+		for i, c := range tt.colors {
+			m.Set(i, 1, c)
+			m.Set(len(tt.colors)-i-1, 0, c)
+		}
+
+		var b bytes.Buffer
+		if err := EncodeWithOptions(&b, m, EncodeOptions{AutoPixelMode: true}); err != nil {
+			t.Fatalf(`err = %v, want nil`, err)
+		}
+		decoded, err := Decode(&b, nil)
+		if err != nil {
+			t.Fatalf(`err = %v, want nil`, err)
+		}
+		if mode := decoded.PixelMode(); mode != tt.want {
+			t.Errorf(`mode for %d colors = %08b, want %08b`, len(tt.colors), mode, tt.want)
+		}
+		for i, c := range tt.colors {
+			CompareColors(t, decoded.At(i, 1), c)
+			CompareColors(t, decoded.At(len(tt.colors)-i-1, 0), c)
+		}
+	}
+}
+
+// TestEncodeAutoPixelModeFallback tests that the model and pixel mode options
+// are used when an image has too many colors.
+func TestEncodeAutoPixelModeFallback(t *testing.T) {
+	m := image.NewRGBA(image.Rect(0, 0, 257, 1))
+	for x := 0; x < 257; x++ {
+		m.Set(x, 0, color.RGBA{uint8(x), uint8(x >> 8), 0, 0xFF})
+	}
+
+	var b bytes.Buffer
+	o := EncodeOptions{PixelMode: TwoBit, AutoPixelMode: true}
+	if err := EncodeWithOptions(&b, m, o); err != nil {
+		t.Fatalf(`err = %v, want nil`, err)
+	}
+	if mode := b.Bytes()[7]; mode != TwoBit|DefaultPaletteFormat {
+		t.Errorf(`mode byte = %08b, want %08b`, mode, TwoBit|DefaultPaletteFormat)
+	}
+}
+
+// TestEncodePaletted tests that the palette and indices of a paletted image
+// are written verbatim.
+func TestEncodePaletted(t *testing.T) {
+	red := color.RGBA{0xFF, 0, 0, 0xFF}
+	m := image.NewPaletted(image.Rect(0, 0, 4, 1), color.Palette{white, red, black})
+	for x := 0; x < 4; x++ {
+		m.SetColorIndex(x, 0, uint8(x%3))
+	}
+
+	var b bytes.Buffer
+	if err := Encode(&b, m, OneBit); err != nil {
+		t.Fatalf(`err = %v, want nil`, err)
+	}
+
+	if mode := b.Bytes()[7]; mode != TwoBit|DefaultPaletteFormat {
+		t.Errorf(`mode byte = %08b, want %08b`, mode, TwoBit|DefaultPaletteFormat)
+	}
+	t.Log("Skipping to palette")
+	b.Next(11)
+	for _, want := range []byte{
+		0xFF, 0xFF, 0xFF, 0xFF,
+		0xFF, 0, 0, 0xFF,
+		0, 0, 0, 0xFF,
+		0, 0, 0, 0,
+	} {
+		FailByteHelper(t, &b, want)
+	}
+	FailByteHelper(t, &b, 0b00_01_10_00)
+}
+
+// TestEncodePalettedWithOptions tests that the palette of a paletted image is
+// not used when an option changes the palette.
+func TestEncodePalettedWithOptions(t *testing.T) {
+	m := image.NewPaletted(image.Rect(0, 0, 4, 4), palette.Plan9)
+	for i := range m.Pix {
+		m.Pix[i] = uint8(i * 16)
+	}
+	tests := []struct {
+		options EncodeOptions
+		want    PixelMode
+	}{
+		{EncodeOptions{PixelMode: TwoBit, AdaptivePalette: MedianCut{}}, TwoBit},
+		{EncodeOptions{PixelMode: OneBit, Dither: FloydSteinberg}, OneBit},
+		{EncodeOptions{PixelMode: OneBit}, EightBit},
+	}
+	for i, tt := range tests {
+		var b bytes.Buffer
+		if err := EncodeWithOptions(&b, m, tt.options); err != nil {
+			t.Fatalf(`test %d: err = %v, want nil`, i, err)
+		}
+		if mode := b.Bytes()[7] & (0b11 << pixelBitsIndex); mode != tt.want {
+			t.Errorf(`test %d: pixel mode = %08b, want %08b`, i, mode, tt.want)
+		}
+	}
+}
+
+// TestEncodePalettedAutoPixelMode tests that a paletted image is written with
+// the smallest pixel mode for the colors it uses, and that its indices are
+// kept if its palette already has that pixel mode.
+func TestEncodePalettedAutoPixelMode(t *testing.T) {
+	m := image.NewPaletted(image.Rect(0, 0, 4, 4), palette.Plan9)
+	for i := range m.Pix {
+		m.Pix[i] = uint8(i % 2 * 0xFF)
+	}
+	var b bytes.Buffer
+	if err := EncodeWithOptions(&b, m, EncodeOptions{AutoPixelMode: true}); err != nil {
+		t.Fatalf(`err = %v, want nil`, err)
+	}
+	if mode := b.Bytes()[7] & (0b11 << pixelBitsIndex); mode != OneBit {
+		t.Errorf(`pixel mode = %08b, want %08b`, mode, OneBit)
+	}
+	decoded, err := Decode(&b, nil)
+	if err != nil {
+		t.Fatalf(`err = %v, want nil`, err)
+	}
+	for x := 0; x < 2; x++ {
+		CompareColors(t, decoded.At(x, 0), m.At(x, 0))
+	}
+
+	small := image.NewPaletted(image.Rect(0, 0, 3, 1), color.Palette{black, white, darkGray})
+	small.Pix = []uint8{2, 1, 0}
+	b.Reset()
+	if err := EncodeWithOptions(&b, small, EncodeOptions{AutoPixelMode: true}); err != nil {
+		t.Fatalf(`err = %v, want nil`, err)
+	}
+	t.Log("Skipping to pixels")
+	b.Next(11 + 16)
+	FailByteHelper(t, &b, 0b10_01_00_00)
+}
+
+// TestEncodeDecodedImage tests that a decoded image is encoded to the same
+// bytes that it was decoded from.
+func TestEncodeDecodedImage(t *testing.T) {
+	tests := []struct {
+		mode    byte
+		palette [][]byte
+		pixels  []byte
+	}{
+		{OneBit, nil, []byte{0b1001_0000}},
+		{TwoBit | WithPalette | RGB, [][]byte{{0b110000_00}, {0b1100_0000}, {0b11_111100}}, []byte{0b00_01_10_11}},
+		{OneBit | WithPalette | Grayscale | EightBitColors, [][]byte{{0x12}, {0xEF}}, []byte{0b0110_0000}},
+		{EightBit | WithPalette | RGBA | EightBitColors, [][]byte{make([]byte, 256*4)}, []byte{0xFF, 0x80, 0, 0x01}},
+	}
+
+	for _, tt := range tests {
+		t.Logf(`Testing mode %08b`, tt.mode)
+		want := MakeImretroReader(tt.mode, tt.palette, 2, 2, tt.pixels).Bytes()
+		decoded, err := Decode(bytes.NewBuffer(want), nil)
+		if err != nil {
+			t.Fatalf(`err = %v, want nil`, err)
+		}
+
+		var b bytes.Buffer
+		if err := Encode(&b, decoded, OneBit); err != nil {
+			t.Fatalf(`err = %v, want nil`, err)
+		}
+		if actual := b.Bytes(); !bytes.Equal(actual, want) {
+			t.Errorf(`encoded bytes = %v, want %v`, actual, want)
+		}
+	}
+}
+
+// TestEncodeDecodedImageNewModel tests that the palette layout of a decoded
+// image is not used when the image is encoded with another model.
+func TestEncodeDecodedImageNewModel(t *testing.T) {
+	red := color.RGBA{0xFF, 0, 0, 0xFF}
+	green := color.RGBA{0, 0xFF, 0, 0xFF}
+	model := ColorModel{red, green}
+	grays := make([]byte, 256)
+	for i := range grays {
+		grays[i] = byte(i)
+	}
+	sprite := MakeImretroReader(OneBit, nil, 2, 1, []byte{0b0100_0000}).Bytes()
+	grayscale := MakeImretroReader(EightBit|WithPalette|Grayscale|EightBitColors, [][]byte{grays}, 2, 1, []byte{76, 150}).Bytes()
+
+	for _, data := range [][]byte{sprite, grayscale} {
+		decoded, err := Decode(bytes.NewBuffer(data), nil)
+		if err != nil {
+			t.Fatalf(`err = %v, want nil`, err)
+		}
+		recolored, err := WithModel(decoded, append(ColorModel(nil), model...).padded(decoded.PixelMode()))
+		if err != nil {
+			t.Fatalf(`err = %v, want nil`, err)
+		}
+		tests := []struct {
+			m image.Image
+			o EncodeOptions
+		}{
+			{decoded, EncodeOptions{Model: model}},
+			{recolored, EncodeOptions{}},
+		}
+		for i, tt := range tests {
+			var b bytes.Buffer
+			if err := EncodeWithOptions(&b, tt.m, tt.o); err != nil {
+				t.Fatalf(`test %d: err = %v, want nil`, i, err)
+			}
+			encoded, err := Decode(&b, nil)
+			if err != nil {
+				t.Fatalf(`test %d: err = %v, want nil`, i, err)
+			}
+			CompareColors(t, encoded.Palette()[0], red)
+			CompareColors(t, encoded.Palette()[1], green)
+		}
+	}
+}
+
+// TestEncodeDecodedImageAdaptivePalette tests that an adaptive palette is
+// written for a decoded image without a palette.
+func TestEncodeDecodedImageAdaptivePalette(t *testing.T) {
+	data := MakeImretroReader(TwoBit, nil, 2, 1, []byte{0b01_10_0000}).Bytes()
+	decoded, err := Decode(bytes.NewBuffer(data), nil)
+	if err != nil {
+		t.Fatalf(`err = %v, want nil`, err)
+	}
+	var b bytes.Buffer
+	o := EncodeOptions{PixelMode: OneBit, AdaptivePalette: MedianCut{}}
+	if err := EncodeWithOptions(&b, decoded, o); err != nil {
+		t.Fatalf(`err = %v, want nil`, err)
+	}
+	encoded, err := Decode(&b, nil)
+	if err != nil {
+		t.Fatalf(`err = %v, want nil`, err)
+	}
+	for x := 0; x < 2; x++ {
+		CompareColors(t, encoded.At(x, 0), decoded.At(x, 0))
+	}
+}
+
+// TestEncoderRows tests that an image written row by row has the same bytes
+// as the image written by Encode.
+func TestEncoderRows(t *testing.T) {
+	tests := []struct {
+		model         ColorModel
+		width, height int
+	}{
+		{Default1BitColorModel, 3, 5},
+		{Default2BitColorModel, 5, 3},
+		{Default2BitColorModel, 4, 2},
+		{Default8BitColorModel, 3, 2},
+		{Default1BitColorModel, 0, 2},
+	}
+	for _, tt := range tests {
+		m := New(image.Rect(0, 0, tt.width, tt.height), tt.model)
+		var b bytes.Buffer
+		e, err := NewEncoder(&b, tt.width, tt.height, EncodeOptions{Model: tt.model})
+		if err != nil {
+			t.Fatalf(`err = %v, want nil`, err)
+		}
+		row := make([]uint8, tt.width)
+		for y := 0; y < tt.height; y++ {
+			for x := range row {
+				row[x] = uint8(x*7 + y*3 + 1)
+				m.SetColorIndex(x, y, row[x])
+			}
+			if err := e.WriteIndices(row); err != nil {
+				t.Fatalf(`err = %v, want nil`, err)
+			}
+		}
+		if err := e.Close(); err != nil {
+			t.Fatalf(`err = %v, want nil`, err)
+		}
+
+		var want bytes.Buffer
+		if err := Encode(&want, m, 0); err != nil {
+			t.Fatalf(`err = %v, want nil`, err)
+		}
+		if !bytes.Equal(b.Bytes(), want.Bytes()) {
+			t.Errorf(`%d-bit %dx%d: bytes = %v, want %v`, tt.model.BitsPerPixel(), tt.width, tt.height, b.Bytes(), want.Bytes())
+		}
+	}
+}
+
+// TestEncoderColors tests that colors written to an Encoder are indexed
+// against the model.
+func TestEncoderColors(t *testing.T) {
+	var b bytes.Buffer
+	e, err := NewEncoder(&b, 3, 1, EncodeOptions{PixelMode: TwoBit, NoPalette: true})
+	if err != nil {
+		t.Fatalf(`err = %v, want nil`, err)
+	}
+	row := []color.Color{color.Black, color.White, color.Gray{0x80}}
+	if err := e.WriteColors(row); err != nil {
+		t.Fatalf(`err = %v, want nil`, err)
+	}
+	if err := e.Close(); err != nil {
+		t.Fatalf(`err = %v, want nil`, err)
+	}
+	b.Next(len(ImretroSignature) + 4)
+	FailByteHelper(t, &b, 0b00_11_10_00)
+}
+
+// TestEncoderCustomModelColors tests that colors written to an Encoder are
+// matched to the closest color of a custom model.
+func TestEncoderCustomModelColors(t *testing.T) {
+	darkBlue := color.RGBA{0, 0, 0x60, 0xFF}
+	var b bytes.Buffer
+	e, err := NewEncoder(&b, 2, 1, EncodeOptions{Model: ColorModel{black, darkBlue}})
+	if err != nil {
+		t.Fatalf(`err = %v, want nil`, err)
+	}
+	if err := e.WriteColors([]color.Color{darkBlue, black}); err != nil {
+		t.Fatalf(`err = %v, want nil`, err)
+	}
+	if err := e.Close(); err != nil {
+		t.Fatalf(`err = %v, want nil`, err)
+	}
+	b.Next(11 + 8)
+	FailByteHelper(t, &b, 0b1000_0000)
+}
+
+// TestEncoderUnpaddedModel tests that a model with fewer colors than its pixel
+// mode supports is written with the bits of the pixel mode.
+func TestEncoderUnpaddedModel(t *testing.T) {
+	model := ColorModel{black, darkGray, white}
+	var b bytes.Buffer
+	e, err := NewEncoder(&b, 4, 1, EncodeOptions{Model: model})
+	if err != nil {
+		t.Fatalf(`err = %v, want nil`, err)
+	}
+	if err := e.WriteIndices([]uint8{0, 1, 2, 1}); err != nil {
+		t.Fatalf(`err = %v, want nil`, err)
+	}
+	if err := e.Close(); err != nil {
+		t.Fatalf(`err = %v, want nil`, err)
+	}
+	m, err := Decode(&b, nil)
+	if err != nil {
+		t.Fatalf(`err = %v, want nil`, err)
+	}
+	for x, want := range []uint8{0, 1, 2, 1} {
+		if actual := m.ColorIndexAt(x, 0); actual != want {
+			t.Errorf(`index at (%d, 0) = %d, want %d`, x, actual, want)
+		}
+	}
+}
+
+// TestEncodeNegativeDimension tests that negative dimensions are rejected.
+func TestEncodeNegativeDimension(t *testing.T) {
+	var b bytes.Buffer
+	if _, err := NewEncoder(&b, -1, 1, EncodeOptions{}); err != ErrNegativeDimension {
+		t.Errorf(`err = %v, want %v`, err, ErrNegativeDimension)
+	}
+	if err := encodeDimensions(&b, 1, -1); err != ErrNegativeDimension {
+		t.Errorf(`err = %v, want %v`, err, ErrNegativeDimension)
+	}
+}
+
+// TestEncoderRowErrors tests that an Encoder only accepts the rows of the
+// image.
+func TestEncoderRowErrors(t *testing.T) {
+	var b bytes.Buffer
+	e, err := NewEncoder(&b, 2, 1, EncodeOptions{PixelMode: OneBit})
+	if err != nil {
+		t.Fatalf(`err = %v, want nil`, err)
+	}
+	if err := e.WriteIndices([]uint8{0}); err != ErrRowLength {
+		t.Errorf(`err = %v, want %v`, err, ErrRowLength)
+	}
+	if err := e.WriteColors(nil); err != ErrRowLength {
+		t.Errorf(`err = %v, want %v`, err, ErrRowLength)
+	}
+	if err := e.Close(); err != ErrMissingRows {
+		t.Errorf(`err = %v, want %v`, err, ErrMissingRows)
+	}
+	if err := e.WriteIndices([]uint8{0, 1}); err != nil {
+		t.Errorf(`err = %v, want nil`, err)
+	}
+	if err := e.WriteIndices([]uint8{0, 1}); err != ErrTooManyRows {
+		t.Errorf(`err = %v, want %v`, err, ErrTooManyRows)
+	}
+
+	if _, err := NewEncoder(&b, 1, 1, EncodeOptions{PixelMode: 0xFF}); err != UnsupportedBitModeError(0xFF) {
+		t.Errorf(`err = %v, want %v`, err, UnsupportedBitModeError(0xFF))
+	}
+}
+
 // FailByteHelper fails if the next byte does not match the wanted value.
 func FailByteHelper(t *testing.T, b *bytes.Buffer, want byte) {
 	t.Helper()
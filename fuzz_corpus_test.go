@@ -0,0 +1,116 @@
+package imretro
+
+import (
+	"bytes"
+	"image"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// FuzzSeeds returns encoded images for each pixel mode and palette format, and
+// their truncated forms.
+func fuzzSeeds(tb testing.TB) [][]byte {
+	var seeds [][]byte
+	for _, model := range []ColorModel{Default1BitColorModel, Default2BitColorModel, Default8BitColorModel} {
+		for _, format := range []ModeFlag{DefaultPaletteFormat, WithPalette | RGB, WithPalette | Grayscale | EightBitColors} {
+			m := New(image.Rect(0, 0, 3, 3), model)
+			m.SetColorIndex(1, 1, uint8(len(model)-1))
+			var b bytes.Buffer
+			if err := EncodeWithOptions(&b, m, EncodeOptions{PaletteFormat: format}); err != nil {
+				tb.Fatalf(`err = %v, want nil`, err)
+			}
+			seeds = append(seeds, b.Bytes(), b.Bytes()[:b.Len()-1])
+		}
+	}
+	seeds = append(seeds, MakeImretroReader(OneBit, nil, 3, 3, []byte{0, 0}).Bytes())
+	return append(seeds, MakeImretroReader(0xFF, nil, 1, 1, []byte{0}).Bytes())
+}
+
+// ReadFuzzCorpus reads the inputs of the seed corpus of a fuzz test in
+// testdata/fuzz.
+func readFuzzCorpus(t *testing.T, name string) [][]byte {
+	paths, err := filepath.Glob(filepath.Join("testdata", "fuzz", name, "*"))
+	if err != nil {
+		t.Fatalf(`err = %v, want nil`, err)
+	}
+	var corpus [][]byte
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatalf(`%s: err = %v, want nil`, path, err)
+		}
+		lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+		if len(lines) != 2 || lines[0] != "go test fuzz v1" {
+			t.Fatalf(`%s: unknown corpus format`, path)
+		}
+		literal := strings.TrimSuffix(strings.TrimPrefix(lines[1], "[]byte("), ")")
+		input, err := strconv.Unquote(literal)
+		if err != nil {
+			t.Fatalf(`%s: err = %v, want nil`, path, err)
+		}
+		corpus = append(corpus, []byte(input))
+	}
+	if len(corpus) == 0 {
+		t.Fatalf(`no seed corpus for %s`, name)
+	}
+	return corpus
+}
+
+// CheckDecode tests that Decode returns an error instead of panicking, and
+// that every pixel of a decoded image can be read.
+func checkDecode(t *testing.T, data []byte) {
+	m, err := Decode(bytes.NewReader(data), nil)
+	if err != nil {
+		return
+	}
+	config, err := DecodeConfig(bytes.NewReader(data), nil)
+	if err != nil {
+		t.Fatalf(`DecodeConfig err = %v, want nil`, err)
+	}
+	bounds := m.Bounds()
+	if bounds.Dx() != config.Width || bounds.Dy() != config.Height {
+		t.Fatalf(`Bounds() = %v, want %dx%d`, bounds, config.Width, config.Height)
+	}
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			m.At(x, y)
+		}
+	}
+}
+
+// CheckDecodeConfig tests that DecodeConfig returns an error instead of
+// panicking.
+func checkDecodeConfig(t *testing.T, data []byte) {
+	config, err := DecodeConfig(bytes.NewReader(data), nil)
+	if err != nil {
+		return
+	}
+	if config.Width > MaximumDimension || config.Height > MaximumDimension {
+		t.Fatalf(`size = %dx%d`, config.Width, config.Height)
+	}
+	if _, ok := config.ColorModel.(ColorModel); !ok {
+		t.Fatalf(`ColorModel() = %T, want ColorModel`, config.ColorModel)
+	}
+}
+
+// TestFuzzCorpus replays the seeds of the fuzz tests, so that they are checked
+// by versions of Go without fuzzing.
+func TestFuzzCorpus(t *testing.T) {
+	tests := []struct {
+		name  string
+		check func(*testing.T, []byte)
+	}{
+		{"FuzzDecode", checkDecode},
+		{"FuzzDecodeConfig", checkDecodeConfig},
+	}
+	for _, tt := range tests {
+		seeds := append(fuzzSeeds(t), readFuzzCorpus(t, tt.name)...)
+		for i, data := range seeds {
+			t.Logf(`Testing %s seed %d`, tt.name, i)
+			tt.check(t, data)
+		}
+	}
+}
@@ -0,0 +1,24 @@
+//go:build go1.18
+// +build go1.18
+
+package imretro
+
+import "testing"
+
+// FuzzDecode tests that Decode returns an error instead of panicking, and that
+// every pixel of a decoded image can be read.
+func FuzzDecode(f *testing.F) {
+	for _, seed := range fuzzSeeds(f) {
+		f.Add(seed)
+	}
+	f.Fuzz(checkDecode)
+}
+
+// FuzzDecodeConfig tests that DecodeConfig returns an error instead of
+// panicking.
+func FuzzDecodeConfig(f *testing.F) {
+	for _, seed := range fuzzSeeds(f) {
+		f.Add(seed)
+	}
+	f.Fuzz(checkDecodeConfig)
+}
@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"image"
 	"image/color"
+	"image/draw"
 
 	"github.com/spenserblack/go-byteutils"
 )
@@ -37,6 +38,10 @@ const paletteIndex byte = 5
 // header.
 const WithPalette byte = 1 << paletteIndex
 
+// PaletteFormatBits are the bits of the mode byte that describe the in-file
+// palette.
+const paletteFormatBits byte = WithPalette | 0b11<<colorChannelIndex | EightBitColors
+
 // ColorChannelIndex is the "index" (from the right) of the bit in the mode byte
 // that signifies the number of color channels in the palette.
 const colorChannelIndex byte = 1
@@ -57,6 +62,10 @@ const colorAccuracyIndex byte = 0
 // use a byte, instead of 2 bits for each color channel.
 const EightBitColors byte = 1 << colorAccuracyIndex
 
+// ReservedModeBits are the bits of the mode byte that are not used yet, and
+// must be 0.
+const reservedModeBits byte = 0b11 << 3
+
 // MaximumDimension is the maximum size of an image's boundary in the imretro
 // format.
 const MaximumDimension int = 0xFFF
@@ -69,6 +78,21 @@ type UnsupportedBitModeError byte
 // have boundaries that are not valid in the encoding.
 type DimensionsTooLargeError int
 
+// ModelSizeError is returned when a color model does not have the number of
+// colors that the pixel mode needs.
+type ModelSizeError struct {
+	PixelMode PixelMode
+	Size      int
+}
+
+// InvalidModeError is returned when a decoded mode byte uses reserved bits, or
+// has an in-file palette with unsupported color channels.
+type InvalidModeError byte
+
+// UnsupportedPaletteFormatError should be returned when the palette format is
+// not a valid union of a color channel flag and the color accuracy flag.
+type UnsupportedPaletteFormatError ModeFlag
+
 // IsBitCountSupported checks if the bit count is supported by the imretro
 // format.
 func IsBitCountSupported(count PixelMode) bool {
@@ -85,12 +109,34 @@ func (e UnsupportedBitModeError) Error() string {
 	return fmt.Sprintf("Unsupported bit count byte: %#b", byte(e))
 }
 
+// Error converts to an error string.
+func (e UnsupportedPaletteFormatError) Error() string {
+	return fmt.Sprintf("Unsupported palette format byte: %#b", byte(e))
+}
+
+// Error converts to an error string.
+func (e InvalidModeError) Error() string {
+	return fmt.Sprintf("Invalid mode byte: %#b", byte(e))
+}
+
+// Is makes the error match ErrInvalidMode.
+func (e InvalidModeError) Is(target error) bool {
+	return target == ErrInvalidMode
+}
+
+// Error reports the size of the model and the size the pixel mode needs.
+func (e ModelSizeError) Error() string {
+	size, _ := modelSize(e.PixelMode)
+	return fmt.Sprintf("Color model has %d colors, want %d", e.Size, size)
+}
+
 // Error makes a string representation of the too-large error.
 func (e DimensionsTooLargeError) Error() string {
 	return fmt.Sprintf("Dimensions too large for 16-bit number: %d", int(e))
 }
 
-// Image is an image decoded from the imretro format.
+// Image is an image decoded from the imretro format. The images returned by
+// Decode are also MutableImage values.
 type Image interface {
 	image.PalettedImage
 	// Palette gets the palette of the image.
@@ -101,10 +147,106 @@ type Image interface {
 	BitsPerPixel() int
 }
 
+// MutableImage is an imretro image whose pixels can be changed.
+type MutableImage interface {
+	Image
+	draw.Image
+	// SetColorIndex sets the palette index of a pixel.
+	SetColorIndex(x, y int, index uint8)
+	// SubImage returns the portion of the image visible through r. The
+	// returned image shares its pixels with the original image.
+	SubImage(r image.Rectangle) image.Image
+}
+
+// New creates an image with the given bounds and color model. Its pixels are
+// stored with the same number of bits as when they are encoded, and all
+// pixels start with the first color of the model.
+//
+// If the model has fewer colors than its pixel mode supports, it is padded
+// with transparent colors. New panics if the model has more than 256 colors.
+func New(r image.Rectangle, model ColorModel) MutableImage {
+	if len(model) > 1<<8 {
+		panic(ErrUnknownModel)
+	}
+	model = model.padded(model.PixelMode())
+	r = r.Canon()
+	width, height := r.Dx(), r.Dy()
+	bits := width * height * model.BitsPerPixel()
+	pixels := make([]byte, (bits+7)/8)
+	return imretroImage{
+		config: image.Config{ColorModel: model, Width: width, Height: height},
+		pixels: pixels,
+		min:    r.Min,
+	}
+}
+
+// WithModel returns an image that shares its pixels with m, but uses a
+// different color model, so that an image can be recolored without decoding
+// it again. The model must have exactly as many colors as the pixel mode of m
+// supports.
+func WithModel(m Image, model ColorModel) (Image, error) {
+	if len(model) != 1<<m.BitsPerPixel() {
+		return nil, ModelSizeError{m.PixelMode(), len(model)}
+	}
+	return withModel(m, model), nil
+}
+
+// WithModel swaps the model of the image without checking its size.
+func withModel(m Image, model ColorModel) Image {
+	if i, ok := m.(imretroImage); ok {
+		// NOTE The palette layout that the image was decoded with may not
+		// keep the colors of the new model.
+		i.config.ColorModel = model
+		i.paletteFormat, i.noPalette = 0, false
+		return i
+	}
+	return modelView{m, model}
+}
+
+// ModelView shows the pixels of an image with a different model.
+type modelView struct {
+	Image
+	model ColorModel
+}
+
+// ColorModel returns the model that the image is shown with.
+func (m modelView) ColorModel() color.Model {
+	return m.model
+}
+
+// At returns the color of the model for the pixel's index.
+func (m modelView) At(x, y int) color.Color {
+	if !image.Pt(x, y).In(m.Bounds()) {
+		return noColor
+	}
+	if index := int(m.ColorIndexAt(x, y)); index < len(m.model) {
+		return m.model[index]
+	}
+	return noColor
+}
+
+// Palette returns the model as a palette.
+func (m modelView) Palette() color.Palette {
+	return color.Palette(m.model)
+}
+
 // ImretroImage is the helper struct for imretro images.
 type imretroImage struct {
 	config image.Config
 	pixels []byte
+	// Min is the top-left corner of the image's bounds.
+	min image.Point
+	// Stride is the number of pixels between vertically adjacent pixels in
+	// the pixel data. If it is 0, the width is the stride.
+	stride int
+	// Offset is the number of pixels in the pixel data before the image's
+	// top-left pixel.
+	offset int
+	// PaletteFormat is the palette layout the image was decoded from. Zero
+	// means that the layout is unknown.
+	paletteFormat ModeFlag
+	// NoPalette is true if the image was decoded without an in-file palette.
+	noPalette bool
 }
 
 // PixelMode returns the pixel mode.
@@ -124,17 +266,20 @@ func (i imretroImage) ColorModel() color.Model {
 
 // Bounds returns the boundaries of the image.
 func (i imretroImage) Bounds() image.Rectangle {
-	return image.Rect(0, 0, i.config.Width, i.config.Height)
+	return image.Rectangle{
+		Min: i.min,
+		Max: i.min.Add(image.Pt(i.config.Width, i.config.Height)),
+	}
 }
 
 // ColorIndexAt converts the x/y coordinates of a pixel to the index in the
-// palette.
+// palette. If the pixel is outside of the bounds, 0 is returned.
 func (i imretroImage) ColorIndexAt(x, y int) uint8 {
-	index := (y * i.config.Width) + x
+	if !image.Pt(x, y).In(i.Bounds()) {
+		return 0
+	}
 	bitsPerPixel := i.BitsPerPixel()
-	offset := index * bitsPerPixel
-	byteIndex := offset / 8
-	bitIndex := byte(offset % 8)
+	byteIndex, bitIndex := i.pixelOffset(x, y, bitsPerPixel)
 	b := i.pixels[byteIndex]
 	bit := byteutils.SliceL(b, bitIndex, bitIndex+byte(bitsPerPixel))
 	return uint8(bit)
@@ -149,7 +294,58 @@ func (i imretroImage) At(x, y int) color.Color {
 	return model[i.ColorIndexAt(x, y)]
 }
 
+// SetColorIndex sets the palette index of the pixel. Pixels outside of the
+// bounds are ignored.
+func (i imretroImage) SetColorIndex(x, y int, index uint8) {
+	if !image.Pt(x, y).In(i.Bounds()) {
+		return
+	}
+	bitsPerPixel := i.BitsPerPixel()
+	byteIndex, bitIndex := i.pixelOffset(x, y, bitsPerPixel)
+	shift := 8 - bitIndex - byte(bitsPerPixel)
+	mask := byte(0xFF>>(8-bitsPerPixel)) << shift
+	i.pixels[byteIndex] = i.pixels[byteIndex]&^mask | (index<<shift)&mask
+}
+
+// Set sets the pixel to the color of the model that is closest to c, like
+// image.Paletted.
+func (i imretroImage) Set(x, y int, c color.Color) {
+	i.SetColorIndex(x, y, i.ColorModel().(ColorModel).NearestIndex(c, nil))
+}
+
 // Palette returns the color model as a palette for the image.
 func (i imretroImage) Palette() color.Palette {
 	return color.Palette(i.ColorModel().(ColorModel))
 }
+
+// PixelOffset returns the byte containing the pixel, and the index of the
+// pixel's first bit from the left of that byte.
+func (i imretroImage) pixelOffset(x, y, bitsPerPixel int) (byteIndex int, bitIndex byte) {
+	index := i.offset + (y-i.min.Y)*i.rowStride() + (x - i.min.X)
+	offset := index * bitsPerPixel
+	return offset / 8, byte(offset % 8)
+}
+
+// SubImage returns an image representing the portion of the image visible
+// through r. The returned image shares its pixels with the original image.
+func (i imretroImage) SubImage(r image.Rectangle) image.Image {
+	r = r.Intersect(i.Bounds())
+	sub := i
+	sub.stride = i.rowStride()
+	if r.Empty() {
+		sub.config.Width, sub.config.Height = 0, 0
+		return sub
+	}
+	sub.config.Width, sub.config.Height = r.Dx(), r.Dy()
+	sub.min = r.Min
+	sub.offset = i.offset + (r.Min.Y-i.min.Y)*sub.stride + (r.Min.X - i.min.X)
+	return sub
+}
+
+// RowStride returns the number of pixels between vertically adjacent pixels.
+func (i imretroImage) rowStride() int {
+	if i.stride == 0 {
+		return i.config.Width
+	}
+	return i.stride
+}
@@ -1,8 +1,10 @@
 package imretro
 
 import (
+	"bytes"
 	"image"
 	"image/color"
+	"image/draw"
 	"testing"
 )
 
@@ -24,6 +26,15 @@ func TestUnsupportedError(t *testing.T) {
 	}
 }
 
+// TestUnsupportedPaletteFormatError tests the error message for unsupported
+// palette formats.
+func TestUnsupportedPaletteFormatError(t *testing.T) {
+	err := UnsupportedPaletteFormatError(0b110)
+	if actual, want := err.Error(), "Unsupported palette format byte: 0b110"; actual != want {
+		t.Fatalf(`err = %q, want %q`, actual, want)
+	}
+}
+
 // TestImagePixelMode tests that an image returns the correct pixel mode.
 func TestImagePixelMode(t *testing.T) {
 	i := imretroImage{}
@@ -70,3 +81,221 @@ func TestDimensionsTooLargeError(t *testing.T) {
 		t.Fatalf(`Error() = %q, want %q`, s, want)
 	}
 }
+
+// TestNew tests that a new image has the expected bounds and palette, and
+// that all of its pixels use the first color.
+func TestNew(t *testing.T) {
+	r := image.Rect(-2, 3, 5, 6)
+	m := New(r, ColorModel{black, white, darkGray})
+
+	if bounds := m.Bounds(); bounds != r {
+		t.Errorf(`bounds = %v, want %v`, bounds, r)
+	}
+	if mode := m.PixelMode(); mode != TwoBit {
+		t.Errorf(`pixel mode = %08b, want %08b`, mode, TwoBit)
+	}
+	if l := len(m.Palette()); l != 4 {
+		t.Fatalf(`len(palette) = %d, want 4`, l)
+	}
+	CompareColors(t, m.Palette()[3], noColor)
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		for x := r.Min.X; x < r.Max.X; x++ {
+			if index := m.ColorIndexAt(x, y); index != 0 {
+				t.Errorf(`index at (%d, %d) = %d, want 0`, x, y, index)
+			}
+		}
+	}
+}
+
+// TestNewTooManyColors tests that New panics when the model has too many
+// colors.
+func TestNewTooManyColors(t *testing.T) {
+	defer func() {
+		if r := recover(); r != ErrUnknownModel {
+			t.Fatalf(`recovered %v, want %v`, r, ErrUnknownModel)
+		}
+	}()
+	New(image.Rect(0, 0, 1, 1), make(ColorModel, 257))
+}
+
+// TestSetColorIndex tests that pixels can be set without changing their
+// neighbors, for each pixel mode.
+func TestSetColorIndex(t *testing.T) {
+	for _, size := range []int{2, 4, 256} {
+		t.Logf(`Testing model with %d colors`, size)
+		r := image.Rect(1, 1, 6, 4)
+		m := New(r, make(ColorModel, size))
+		want := make(map[image.Point]uint8)
+		for y := r.Min.Y; y < r.Max.Y; y++ {
+			for x := r.Min.X; x < r.Max.X; x++ {
+				index := uint8((x*7 + y*3) % size)
+				m.SetColorIndex(x, y, index)
+				want[image.Pt(x, y)] = index
+			}
+		}
+		m.SetColorIndex(0, 0, 1)
+		m.SetColorIndex(6, 4, 1)
+		for p, index := range want {
+			if actual := m.ColorIndexAt(p.X, p.Y); actual != index {
+				t.Errorf(`index at %v = %d, want %d`, p, actual, index)
+			}
+		}
+	}
+}
+
+// TestColorIndexAtOutOfBounds tests that the index of a pixel outside of the
+// bounds is 0, like image.Paletted.
+func TestColorIndexAtOutOfBounds(t *testing.T) {
+	m := New(image.Rect(0, 0, 2, 2), Default2BitColorModel)
+	for y := 0; y < 2; y++ {
+		for x := 0; x < 2; x++ {
+			m.SetColorIndex(x, y, 3)
+		}
+	}
+	for _, p := range []image.Point{{-1, 0}, {2, 0}, {0, 2}, {100, 100}} {
+		if index := m.ColorIndexAt(p.X, p.Y); index != 0 {
+			t.Errorf(`index at %v = %d, want 0`, p, index)
+		}
+	}
+	sub := m.SubImage(image.Rect(1, 1, 2, 2)).(MutableImage)
+	if index := sub.ColorIndexAt(0, 1); index != 0 {
+		t.Errorf(`index outside of sub-image = %d, want 0`, index)
+	}
+}
+
+// TestNewDrawAndEncode tests that an image can be drawn to and then encoded.
+func TestNewDrawAndEncode(t *testing.T) {
+	green := color.RGBA{0, 0xFF, 0, 0xFF}
+	m := New(image.Rect(0, 0, 4, 2), ColorModel{black, green})
+	draw.Draw(m, image.Rect(1, 0, 3, 2), image.NewUniform(green), image.Point{}, draw.Src)
+
+	var b bytes.Buffer
+	if err := Encode(&b, m, EightBit); err != nil {
+		t.Fatalf(`err = %v, want nil`, err)
+	}
+	decoded, err := Decode(&b, nil)
+	if err != nil {
+		t.Fatalf(`err = %v, want nil`, err)
+	}
+	for y := 0; y < 2; y++ {
+		for x := 0; x < 4; x++ {
+			want := black
+			if x == 1 || x == 2 {
+				want = green
+			}
+			CompareColors(t, decoded.At(x, y), want)
+		}
+	}
+}
+
+// TestSetNearestColor tests that Set picks the closest color of a custom
+// model.
+func TestSetNearestColor(t *testing.T) {
+	red := color.RGBA{0xFF, 0, 0, 0xFF}
+	model := ColorModel{
+		red,
+		color.RGBA{0, 0xFF, 0, 0xFF},
+		color.RGBA{0, 0, 0xFF, 0xFF},
+		color.RGBA{0xFF, 0xFF, 0, 0xFF},
+	}
+	m := New(image.Rect(0, 0, 2, 1), model)
+	draw.Draw(m, m.Bounds(), image.NewUniform(red), image.Point{}, draw.Src)
+	m.Set(1, 0, color.RGBA{0xF0, 0xF0, 0x10, 0xFF})
+	for x, want := range []uint8{0, 3} {
+		if actual := m.ColorIndexAt(x, 0); actual != want {
+			t.Errorf(`index at (%d, 0) = %d, want %d`, x, actual, want)
+		}
+	}
+}
+
+// TestSubImage tests that a sub-image has the pixels of the original image,
+// and that it shares them with the original image.
+func TestSubImage(t *testing.T) {
+	r := MakeImretroReader(TwoBit, nil, 5, 3, []byte{0b00011011, 0b11_100100, 0b1101_0011, 0b1001_0000})
+	decoded, err := Decode(r, nil)
+	if err != nil {
+		t.Fatalf(`err = %v, want nil`, err)
+	}
+	original := decoded.(MutableImage)
+
+	sub := original.SubImage(image.Rect(1, 1, 4, 5)).(MutableImage)
+	if bounds, want := sub.Bounds(), image.Rect(1, 1, 4, 3); bounds != want {
+		t.Fatalf(`bounds = %v, want %v`, bounds, want)
+	}
+	for y := 1; y < 3; y++ {
+		for x := 1; x < 4; x++ {
+			if actual, want := sub.ColorIndexAt(x, y), original.ColorIndexAt(x, y); actual != want {
+				t.Errorf(`index at (%d, %d) = %d, want %d`, x, y, actual, want)
+			}
+		}
+	}
+	CompareColors(t, sub.At(0, 0), noColor)
+
+	subSub := sub.SubImage(image.Rect(2, 2, 3, 3)).(MutableImage)
+	subSub.SetColorIndex(2, 2, 0b10)
+	if index := original.ColorIndexAt(2, 2); index != 0b10 {
+		t.Errorf(`index in original image = %d, want %d`, index, 0b10)
+	}
+	before := original.ColorIndexAt(3, 2)
+	subSub.SetColorIndex(3, 2, before+1)
+	if index := original.ColorIndexAt(3, 2); index != before {
+		t.Errorf(`pixel outside of sub-image was set to %d`, index)
+	}
+
+	if empty := original.SubImage(image.Rect(10, 10, 20, 20)); !empty.Bounds().Empty() {
+		t.Errorf(`bounds = %v, want empty`, empty.Bounds())
+	}
+}
+
+// TestEncodeSubImage tests that only the pixels of a sub-image are encoded.
+func TestEncodeSubImage(t *testing.T) {
+	m := New(image.Rect(0, 0, 4, 4), Default1BitColorModel)
+	m.SetColorIndex(1, 1, 1)
+	m.SetColorIndex(2, 2, 1)
+	sub := m.SubImage(image.Rect(1, 1, 3, 3))
+
+	var b bytes.Buffer
+	if err := Encode(&b, sub, OneBit); err != nil {
+		t.Fatalf(`err = %v, want nil`, err)
+	}
+	t.Log("Skipping to pixels")
+	b.Next(11 + 8)
+	FailByteHelper(t, &b, 0b1001_0000)
+}
+
+// TestWithModel tests that an image with a swapped model shares its pixels.
+func TestWithModel(t *testing.T) {
+	m := New(image.Rect(0, 0, 2, 1), Default1BitColorModel)
+	team := NewOneBitColorModel(color.Gray{0}, color.RGBA{0xFF, 0, 0, 0xFF})
+	recolored, err := WithModel(m, team)
+	if err != nil {
+		t.Fatalf(`err = %v, want nil`, err)
+	}
+	m.SetColorIndex(1, 0, 1)
+	CompareColors(t, recolored.At(1, 0), team[1])
+	CompareColors(t, m.At(1, 0), Default1BitColorModel[1])
+
+	sub := m.SubImage(image.Rect(1, 0, 2, 1)).(Image)
+	recolored, err = WithModel(sub, team)
+	if err != nil {
+		t.Fatalf(`err = %v, want nil`, err)
+	}
+	if actual := recolored.Bounds(); actual != sub.Bounds() {
+		t.Errorf(`Bounds() = %v, want %v`, actual, sub.Bounds())
+	}
+	CompareColors(t, recolored.At(1, 0), team[1])
+}
+
+// TestWithModelWrongSize tests that a model with the wrong number of colors
+// is rejected.
+func TestWithModelWrongSize(t *testing.T) {
+	m := New(image.Rect(0, 0, 1, 1), Default2BitColorModel)
+	_, err := WithModel(m, Default1BitColorModel)
+	want := ModelSizeError{TwoBit, 2}
+	if err != want {
+		t.Fatalf(`err = %v, want %v`, err, want)
+	}
+	if actual := err.Error(); actual != "Color model has 2 colors, want 4" {
+		t.Errorf(`Error() = %q`, actual)
+	}
+}
@@ -21,3 +21,10 @@ func FillByte(b byte, n byte) byte {
 	}
 	return bb
 }
+
+// ShrinkByte scales a byte down to the nearest n-bit value. It is the inverse of
+// FillByte.
+func ShrinkByte(b byte, n byte) byte {
+	max := uint(1)<<n - 1
+	return byte((uint(b)*max + 0x7F) / 0xFF)
+}
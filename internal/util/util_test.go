@@ -25,3 +25,22 @@ func TestFillBytes(t *testing.T) {
 		}
 	}
 }
+
+// TestShrinkByte tests that a byte would be scaled down to the nearest n-bit
+// value.
+func TestShrinkByte(t *testing.T) {
+	tests := []struct {
+		b    byte
+		n    byte
+		want byte
+	}{
+		{0, 2, 0b00}, {0x2A, 2, 0b00}, {0x2B, 2, 0b01}, {0x55, 2, 0b01},
+		{0x80, 2, 0b10}, {0xFF, 2, 0b11}, {0x11, 4, 0b0001}, {0xAB, 8, 0xAB},
+	}
+
+	for _, tt := range tests {
+		if actual := ShrinkByte(tt.b, tt.n); actual != tt.want {
+			t.Errorf(`ShrinkByte(%02X, %d) = %b, want %b`, tt.b, tt.n, actual, tt.want)
+		}
+	}
+}
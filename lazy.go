@@ -0,0 +1,165 @@
+package imretro
+
+import (
+	"image"
+	"image/color"
+	"io"
+	"math"
+)
+
+// LazyImage is an imretro image whose pixels are read from an io.ReaderAt
+// when they are needed, so that parts of large images can be used without
+// loading the whole image.
+type LazyImage struct {
+	r      io.ReaderAt
+	config image.Config
+	// PixelStart is the offset of the first byte of the pixels in r.
+	pixelStart int64
+	min        image.Point
+	// Stride and offset are the number of pixels between vertically adjacent
+	// pixels, and the number of pixels before the image's top-left pixel, like
+	// imretroImage.
+	stride int
+	offset int
+}
+
+// OpenReaderAt reads the header and palette of an imretro image from r and
+// returns an image that reads its pixels from r. The custom models are used
+// like Decode. If r does not have all of the pixels, a DecodeError that matches
+// ErrTruncatedPixels is returned.
+func OpenReaderAt(r io.ReaderAt, customModels CustomModel) (*LazyImage, error) {
+	section := io.NewSectionReader(r, 0, math.MaxInt64)
+	config, err := DecodeConfig(section, customModels)
+	if err != nil {
+		return nil, err
+	}
+	pixelStart, _ := section.Seek(0, io.SeekCurrent)
+	m := &LazyImage{r: r, config: config, pixelStart: pixelStart, stride: config.Width}
+
+	bits := config.Width * config.Height * m.BitsPerPixel()
+	if bits > 0 {
+		last := make([]byte, 1)
+		lastOffset := pixelStart + int64((bits-1)/8)
+		if n, err := r.ReadAt(last, lastOffset); n != len(last) {
+			return nil, newDecodeError(PhasePixels, lastOffset, readAtError(err))
+		}
+	}
+	return m, nil
+}
+
+// PixelMode returns the pixel mode.
+func (m *LazyImage) PixelMode() PixelMode {
+	return m.config.ColorModel.(ColorModel).PixelMode()
+}
+
+// BitsPerPixel returns the number of bits used for each pixel.
+func (m *LazyImage) BitsPerPixel() int {
+	return m.config.ColorModel.(ColorModel).BitsPerPixel()
+}
+
+// ColorModel returns the image's color model.
+func (m *LazyImage) ColorModel() color.Model {
+	return m.config.ColorModel
+}
+
+// Palette returns the color model as a palette for the image.
+func (m *LazyImage) Palette() color.Palette {
+	return color.Palette(m.config.ColorModel.(ColorModel))
+}
+
+// Bounds returns the boundaries of the image.
+func (m *LazyImage) Bounds() image.Rectangle {
+	return image.Rectangle{
+		Min: m.min,
+		Max: m.min.Add(image.Pt(m.config.Width, m.config.Height)),
+	}
+}
+
+// ColorIndexAt reads the palette index of the pixel. If the pixel is outside
+// of the bounds or cannot be read, 0 is returned.
+func (m *LazyImage) ColorIndexAt(x, y int) uint8 {
+	if !image.Pt(x, y).In(m.Bounds()) {
+		return 0
+	}
+	bitsPerPixel := m.BitsPerPixel()
+	offset := m.pixelIndex(x, y) * bitsPerPixel
+	b := make([]byte, 1)
+	if n, _ := m.r.ReadAt(b, m.pixelStart+int64(offset/8)); n != len(b) {
+		return 0
+	}
+	return indexInByte(b[0], offset%8, bitsPerPixel)
+}
+
+// At reads the color of the pixel.
+func (m *LazyImage) At(x, y int) color.Color {
+	if !image.Pt(x, y).In(m.Bounds()) {
+		return noColor
+	}
+	return m.config.ColorModel.(ColorModel)[m.ColorIndexAt(x, y)]
+}
+
+// SubImage returns an image representing the portion of the image visible
+// through r. The returned image reads its pixels from the same io.ReaderAt.
+func (m *LazyImage) SubImage(r image.Rectangle) image.Image {
+	r = r.Intersect(m.Bounds())
+	sub := *m
+	if r.Empty() {
+		sub.config.Width, sub.config.Height = 0, 0
+		return &sub
+	}
+	sub.config.Width, sub.config.Height = r.Dx(), r.Dy()
+	sub.min = r.Min
+	sub.offset = m.pixelIndex(r.Min.X, r.Min.Y)
+	return &sub
+}
+
+// Load reads the pixels in r into memory. Only the bytes that contain those
+// pixels are read. Errors are returned as a DecodeError with the offset of the
+// pixels that could not be read.
+func (m *LazyImage) Load(r image.Rectangle) (MutableImage, error) {
+	r = r.Intersect(m.Bounds())
+	dst := New(r, m.config.ColorModel.(ColorModel))
+	bitsPerPixel := m.BitsPerPixel()
+	var buffer []byte
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		start := m.pixelIndex(r.Min.X, y) * bitsPerPixel
+		end := start + r.Dx()*bitsPerPixel
+		size := (end+7)/8 - start/8
+		if cap(buffer) < size {
+			buffer = make([]byte, size)
+		}
+		buffer = buffer[:size]
+		// NOTE ReadAt may return io.EOF with all of the bytes at the end of
+		// the reader.
+		offset := m.pixelStart + int64(start/8)
+		if n, err := m.r.ReadAt(buffer, offset); n != size {
+			return nil, newDecodeError(PhasePixels, offset+int64(n), readAtError(err))
+		}
+		for x := r.Min.X; x < r.Max.X; x++ {
+			offset := start%8 + (x-r.Min.X)*bitsPerPixel
+			dst.SetColorIndex(x, y, indexInByte(buffer[offset/8], offset%8, bitsPerPixel))
+		}
+	}
+	return dst, nil
+}
+
+// ReadAtError returns the error of a short read from an io.ReaderAt, or
+// io.ErrUnexpectedEOF if the reader did not return an error.
+func readAtError(err error) error {
+	if err == nil {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// PixelIndex returns the number of pixels before the pixel in the pixel data.
+func (m *LazyImage) pixelIndex(x, y int) int {
+	return m.offset + (y-m.min.Y)*m.stride + (x - m.min.X)
+}
+
+// IndexInByte returns the palette index that starts at the bit index from the
+// left of the byte.
+func indexInByte(b byte, bitIndex, bitsPerPixel int) uint8 {
+	mask := byte(0xFF >> (8 - bitsPerPixel))
+	return b >> (8 - bitsPerPixel - bitIndex) & mask
+}
@@ -0,0 +1,161 @@
+package imretro
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"io"
+	"testing"
+)
+
+// CountingReaderAt counts the bytes read from a ReaderAt.
+type countingReaderAt struct {
+	r     io.ReaderAt
+	count int
+}
+
+func (r *countingReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	n, err := r.r.ReadAt(p, off)
+	r.count += n
+	return n, err
+}
+
+// LazyTestImage encodes an image with different indices for its pixels, and
+// returns the image and the encoded bytes.
+func lazyTestImage(t *testing.T, model ColorModel, width, height int) (MutableImage, []byte) {
+	t.Helper()
+	m := New(image.Rect(0, 0, width, height), model)
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			m.SetColorIndex(x, y, uint8(x*5+y*3+x*y))
+		}
+	}
+	var b bytes.Buffer
+	if err := Encode(&b, m, 0); err != nil {
+		t.Fatalf(`err = %v, want nil`, err)
+	}
+	return m, b.Bytes()
+}
+
+// TestLazyImage tests that the pixels of a LazyImage match the encoded image.
+func TestLazyImage(t *testing.T) {
+	for _, model := range []ColorModel{Default1BitColorModel, Default2BitColorModel, Default8BitColorModel} {
+		want, data := lazyTestImage(t, model, 7, 5)
+		m, err := OpenReaderAt(bytes.NewReader(data), nil)
+		if err != nil {
+			t.Fatalf(`err = %v, want nil`, err)
+		}
+		if actual := m.Bounds(); actual != want.Bounds() {
+			t.Fatalf(`Bounds() = %v, want %v`, actual, want.Bounds())
+		}
+		if actual := m.PixelMode(); actual != want.PixelMode() {
+			t.Errorf(`PixelMode() = %08b, want %08b`, actual, want.PixelMode())
+		}
+		for y := 0; y < 5; y++ {
+			for x := 0; x < 7; x++ {
+				if actual, index := m.ColorIndexAt(x, y), want.ColorIndexAt(x, y); actual != index {
+					t.Errorf(`%d-bit: index at (%d, %d) = %d, want %d`, model.BitsPerPixel(), x, y, actual, index)
+				}
+				CompareColors(t, m.At(x, y), want.At(x, y))
+			}
+		}
+		CompareColors(t, m.At(-1, 0), noColor)
+	}
+}
+
+// TestLazyImageSubImage tests that a sub-image and loaded pixels match the
+// encoded image, and that only the needed bytes are read.
+func TestLazyImageSubImage(t *testing.T) {
+	want, data := lazyTestImage(t, Default8BitColorModel, 100, 100)
+	r := &countingReaderAt{r: bytes.NewReader(data)}
+	m, err := OpenReaderAt(r, nil)
+	if err != nil {
+		t.Fatalf(`err = %v, want nil`, err)
+	}
+	rect := image.Rect(10, 20, 13, 22)
+	sub := m.SubImage(rect).(*LazyImage)
+	if actual := sub.Bounds(); actual != rect {
+		t.Fatalf(`Bounds() = %v, want %v`, actual, rect)
+	}
+
+	r.count = 0
+	loaded, err := sub.Load(image.Rect(0, 0, 100, 100))
+	if err != nil {
+		t.Fatalf(`err = %v, want nil`, err)
+	}
+	if r.count != 6 {
+		t.Errorf(`bytes read = %d, want 6`, r.count)
+	}
+	if actual := loaded.Bounds(); actual != rect {
+		t.Fatalf(`Bounds() = %v, want %v`, actual, rect)
+	}
+	for y := rect.Min.Y; y < rect.Max.Y; y++ {
+		for x := rect.Min.X; x < rect.Max.X; x++ {
+			index := want.ColorIndexAt(x, y)
+			if actual := sub.ColorIndexAt(x, y); actual != index {
+				t.Errorf(`sub-image index at (%d, %d) = %d, want %d`, x, y, actual, index)
+			}
+			if actual := loaded.ColorIndexAt(x, y); actual != index {
+				t.Errorf(`loaded index at (%d, %d) = %d, want %d`, x, y, actual, index)
+			}
+		}
+	}
+}
+
+// TestLazyImageLoadPacked tests that pixels that share bytes are loaded.
+func TestLazyImageLoadPacked(t *testing.T) {
+	want, data := lazyTestImage(t, Default2BitColorModel, 7, 5)
+	m, err := OpenReaderAt(bytes.NewReader(data), nil)
+	if err != nil {
+		t.Fatalf(`err = %v, want nil`, err)
+	}
+	rect := image.Rect(1, 1, 6, 4)
+	loaded, err := m.Load(rect)
+	if err != nil {
+		t.Fatalf(`err = %v, want nil`, err)
+	}
+	for y := rect.Min.Y; y < rect.Max.Y; y++ {
+		for x := rect.Min.X; x < rect.Max.X; x++ {
+			if actual, index := loaded.ColorIndexAt(x, y), want.ColorIndexAt(x, y); actual != index {
+				t.Errorf(`index at (%d, %d) = %d, want %d`, x, y, actual, index)
+			}
+		}
+	}
+}
+
+// TestOpenReaderAtMissingPixels tests that an image without all of its pixels
+// cannot be opened.
+func TestOpenReaderAtMissingPixels(t *testing.T) {
+	_, data := lazyTestImage(t, Default8BitColorModel, 4, 4)
+	_, err := OpenReaderAt(bytes.NewReader(data[:len(data)-1]), nil)
+	if !errors.Is(err, ErrTruncatedPixels) || !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Fatalf(`err = %v, want %v`, err, ErrTruncatedPixels)
+	}
+	var decodeErr *DecodeError
+	if !errors.As(err, &decodeErr) || decodeErr.Phase != PhasePixels || decodeErr.Offset != int64(len(data)-1) {
+		t.Errorf(`err = %#v, want pixels error at byte %d`, err, len(data)-1)
+	}
+}
+
+// TestLazyImageLoadMissingPixels tests that pixels that cannot be read are
+// reported as truncated pixels.
+func TestLazyImageLoadMissingPixels(t *testing.T) {
+	_, data := lazyTestImage(t, Default8BitColorModel, 4, 4)
+	m, err := OpenReaderAt(bytes.NewReader(data), nil)
+	if err != nil {
+		t.Fatalf(`err = %v, want nil`, err)
+	}
+	m.r = bytes.NewReader(data[:len(data)-2])
+
+	if _, err := m.Load(image.Rect(0, 0, 4, 3)); err != nil {
+		t.Fatalf(`err = %v, want nil`, err)
+	}
+	_, err = m.Load(m.Bounds())
+	if !errors.Is(err, ErrTruncatedPixels) {
+		t.Fatalf(`err = %v, want %v`, err, ErrTruncatedPixels)
+	}
+	var decodeErr *DecodeError
+	if !errors.As(err, &decodeErr) || decodeErr.Phase != PhasePixels || decodeErr.Offset != int64(len(data)-2) {
+		t.Errorf(`err = %#v, want pixels error at byte %d`, err, len(data)-2)
+	}
+}
@@ -3,7 +3,9 @@ package imretro
 import (
 	"errors"
 	"fmt"
+	"image"
 	"image/color"
+	"math"
 	"math/bits"
 
 	"github.com/spenserblack/go-byteutils"
@@ -40,6 +42,12 @@ func (mode MissingModelError) Error() string {
 	return fmt.Sprintf("No model for pixel mode %02b", mode)
 }
 
+// Is makes the error match ErrInvalidMode if the pixel mode is not supported
+// by the format.
+func (mode MissingModelError) Is(target error) bool {
+	return target == ErrInvalidMode && !IsBitCountSupported(PixelMode(mode))
+}
+
 // Default color models/palettes adhering to the defaults defined in the format
 // documentation.
 var (
@@ -75,6 +83,36 @@ func (model ColorModel) BitsPerPixel() int {
 	return bits.TrailingZeros(uint(len(model)))
 }
 
+// ModelSize returns the number of colors in a palette for the pixel mode. Ok
+// is false if the pixel mode is not supported.
+func modelSize(mode PixelMode) (size int, ok bool) {
+	switch mode {
+	case OneBit:
+		return 1 << 1, true
+	case TwoBit:
+		return 1 << 2, true
+	case EightBit:
+		return 1 << 8, true
+	}
+	return 0, false
+}
+
+// Padded returns the model with transparent colors added, so that it has as
+// many colors as the pixel mode needs. The model itself is returned if it
+// already has enough colors.
+func (model ColorModel) padded(mode PixelMode) ColorModel {
+	size, _ := modelSize(mode)
+	if len(model) >= size {
+		return model
+	}
+	full := make(ColorModel, size)
+	copy(full, model)
+	for i := len(model); i < size; i++ {
+		full[i] = noColor
+	}
+	return full
+}
+
 // NewOneBitColorModel creates a new color model for 1-bit-pixel images.
 func NewOneBitColorModel(off color.Color, on color.Color) ColorModel {
 	return ColorModel{off, on}
@@ -115,7 +153,7 @@ func (model ColorModel) Index(c color.Color) uint8 {
 // necessarily the closest color. For example, RGBA 255, 255, 255, 0 would
 // always map to the "off" color of a 1-bit model, even if the "on" color is
 // RGBA 255, 255, 255, 0. This is because a transparent color is considered
-// to be off.
+// to be off. NearestModel can be used to map to the closest color instead.
 func (model ColorModel) Convert(c color.Color) color.Color {
 	index := model.Index(c)
 	if int(index) >= len(model) {
@@ -124,11 +162,102 @@ func (model ColorModel) Convert(c color.Color) color.Color {
 	return model[index]
 }
 
+// NearestIndex returns the index of the color in the model that is the closest
+// to c according to the distance function. If multiple colors are equally
+// close, the lowest index is returned. If distance is nil, EuclideanDistance is
+// used.
+func (model ColorModel) NearestIndex(c color.Color, distance ColorDistance) uint8 {
+	if distance == nil {
+		distance = EuclideanDistance
+	}
+	var nearest uint8
+	best := math.Inf(1)
+	for i, candidate := range model {
+		d := distance(c, candidate)
+		if d < best {
+			nearest, best = uint8(i), d
+		}
+		if d == 0 {
+			break
+		}
+	}
+	return nearest
+}
+
 // ColorModel will always return itself and ok.
 func (model ColorModel) ColorModel(PixelMode) (self color.Model, ok bool) {
 	return model, true
 }
 
+// NearestModel is a color model that maps each color to the closest color in a
+// ColorModel, instead of using the thresholds of ColorModel.Index. This makes
+// the actual colors of a custom palette matter when converting.
+type NearestModel struct {
+	// Model is the palette that colors are matched against.
+	Model ColorModel
+	// Distance measures how close colors are. If it is nil,
+	// EuclideanDistance is used.
+	Distance ColorDistance
+}
+
+// Index returns the index of the closest palette color.
+func (m NearestModel) Index(c color.Color) uint8 {
+	return m.Model.NearestIndex(c, m.Distance)
+}
+
+// Convert maps a color to the closest color in the palette.
+func (m NearestModel) Convert(c color.Color) color.Color {
+	if len(m.Model) == 0 {
+		return noColor
+	}
+	return m.Model[m.Index(c)]
+}
+
+// ImageModel creates a color model from the distinct colors of the image, in
+// the order that they first appear. The length of the model decides the
+// smallest pixel mode that can hold all the colors. Ok is false if the image
+// has more than 256 colors.
+func ImageModel(m image.Image) (model ColorModel, ok bool) {
+	model, _, ok = exactModel(m)
+	return
+}
+
+// ExactModel creates a model from the distinct colors of the image, and an
+// index to find each color's position in the model.
+func exactModel(m image.Image) (model ColorModel, index colorIndex, ok bool) {
+	model = ColorModel{}
+	index = make(colorIndex)
+	bounds := m.Bounds()
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			key := colorKey(m.At(x, y))
+			if _, seen := index[key]; seen {
+				continue
+			}
+			if len(model) == 1<<8 {
+				return nil, nil, false
+			}
+			index[key] = uint8(len(model))
+			model = append(model, color.RGBA{key[0], key[1], key[2], key[3]})
+		}
+	}
+	return model, index, true
+}
+
+// ColorIndex maps the bytes of colors to their index in a palette.
+type colorIndex map[[4]byte]uint8
+
+// Index returns the index of the color, or 0 if the color is not indexed.
+func (index colorIndex) Index(c color.Color) uint8 {
+	return index[colorKey(c)]
+}
+
+// ColorKey converts a color to bytes that can be used as a map key.
+func colorKey(c color.Color) [4]byte {
+	r, g, b, a := util.ColorAsBytes(c)
+	return [4]byte{r, g, b, a}
+}
+
 func init() {
 	// NOTE Sets the colors for the default 8-bit color model.
 	for i := range Default8BitColorModel {
@@ -1,6 +1,7 @@
 package imretro
 
 import (
+	"image"
 	"image/color"
 	"testing"
 )
@@ -98,3 +99,71 @@ func Test8BitModelIndex(t *testing.T) {
 		t.Errorf(`bits = %02b, want 11101010`, bits)
 	}
 }
+
+// TestModelNearestIndex tests that the closest color in the palette is picked.
+func TestModelNearestIndex(t *testing.T) {
+	green := color.RGBA{0, 0xFF, 0, 0xFF}
+	model := ColorModel{black, green}
+
+	tests := []struct {
+		input color.Color
+		want  uint8
+	}{
+		{color.RGBA{0xFF, 0, 0, 0xFF}, 0},
+		{color.RGBA{0x20, 0xC0, 0x20, 0xFF}, 1},
+		{black, 0},
+		{green, 1},
+	}
+	for _, tt := range tests {
+		if index := model.NearestIndex(tt.input, nil); index != tt.want {
+			t.Errorf(`index of %v = %d, want %d`, tt.input, index, tt.want)
+		}
+	}
+}
+
+// TestNearestModelConvert tests that a color is converted to the closest color
+// in the palette.
+func TestNearestModelConvert(t *testing.T) {
+	red := color.RGBA{0xFF, 0, 0, 0xFF}
+	blue := color.RGBA{0, 0, 0xFF, 0xFF}
+	model := NearestModel{Model: ColorModel{noColor, red, blue}}
+
+	CompareColors(t, model.Convert(color.RGBA{0xC0, 0, 0x40, 0xFF}), red)
+	CompareColors(t, model.Convert(color.RGBA{0, 0x10, 0xE0, 0xFF}), blue)
+	CompareColors(t, model.Convert(color.RGBA{0, 0, 0, 0x10}), noColor)
+	CompareColors(t, NearestModel{}.Convert(red), noColor)
+}
+
+// TestImageModel tests that a model is made from the distinct colors of an
+// image.
+func TestImageModel(t *testing.T) {
+	red := color.RGBA{0xFF, 0, 0, 0xFF}
+	m := image.NewRGBA(image.Rect(0, 0, 3, 2))
+	m.Set(0, 0, red)
+	m.Set(1, 0, black)
+	m.Set(2, 0, red)
+	m.Set(0, 1, white)
+
+	model, ok := ImageModel(m)
+	if !ok {
+		t.Fatalf(`ok = false`)
+	}
+	want := ColorModel{red, black, white, noColor}
+	if l := len(model); l != len(want) {
+		t.Fatalf(`len(model) = %d, want %d`, l, len(want))
+	}
+	for i, c := range want {
+		CompareColors(t, model[i], c)
+	}
+	if mode := model.PixelMode(); mode != TwoBit {
+		t.Errorf(`mode = %08b, want %08b`, mode, TwoBit)
+	}
+
+	many := image.NewRGBA(image.Rect(0, 0, 257, 1))
+	for x := 0; x < 257; x++ {
+		many.Set(x, 0, color.RGBA{uint8(x), uint8(x >> 8), 0, 0xFF})
+	}
+	if _, ok := ImageModel(many); ok {
+		t.Errorf(`ok = true for 257 colors`)
+	}
+}
@@ -0,0 +1,408 @@
+package imretro
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"sort"
+)
+
+// Quantizer creates a palette that fits the colors of an image.
+type Quantizer interface {
+	// Quantize creates a model of at most size colors for the image.
+	Quantize(m image.Image, size int) ColorModel
+}
+
+// AdaptiveModel creates a color model for the pixel mode that is made for the
+// colors of m. The model may have fewer colors than the pixel mode supports
+// if the image has few colors.
+func AdaptiveModel(m image.Image, mode PixelMode, q Quantizer) (ColorModel, error) {
+	size, ok := modelSize(mode)
+	if !ok {
+		return nil, UnsupportedBitModeError(mode)
+	}
+	return q.Quantize(m, size), nil
+}
+
+// MedianCut is a Quantizer that repeatedly splits the box of colors with the
+// widest range of a channel at its median, until there are enough boxes. Each
+// box is then averaged into a single color.
+type MedianCut struct{}
+
+// Quantize creates a model with the median cut algorithm.
+func (MedianCut) Quantize(m image.Image, size int) ColorModel {
+	boxes := []colorBox{colorHistogram(m)}
+	for len(boxes) < size {
+		widest, widestRange := -1, 0
+		for i, box := range boxes {
+			if _, r := box.widestChannel(); r > widestRange && len(box) > 1 {
+				widest, widestRange = i, r
+			}
+		}
+		if widest < 0 {
+			break
+		}
+		low, high := boxes[widest].split()
+		boxes[widest] = low
+		boxes = append(boxes, high)
+	}
+
+	model := make(ColorModel, 0, len(boxes))
+	for _, box := range boxes {
+		if len(box) > 0 {
+			model = append(model, box.average())
+		}
+	}
+	return model
+}
+
+// Octree is a Quantizer that sorts colors into a tree using the bits of their
+// channels, and then merges the leaves with the fewest pixels into their
+// parents until there are few enough leaves.
+type Octree struct{}
+
+// Quantize creates a model with octree quantization.
+func (Octree) Quantize(m image.Image, size int) ColorModel {
+	root := new(octreeNode)
+	// NOTE Levels stores the nodes with children at each depth.
+	levels := make([][]*octreeNode, 8)
+	leaves := 0
+	for _, entry := range colorHistogram(m) {
+		node := root
+		for depth := 0; depth < 8; depth++ {
+			childIndex := octreeChildIndex(entry.c, depth)
+			if node.children == nil {
+				node.children = new([16]*octreeNode)
+				levels[depth] = append(levels[depth], node)
+			}
+			if node.children[childIndex] == nil {
+				node.children[childIndex] = new(octreeNode)
+				if depth == 7 {
+					leaves++
+				}
+			}
+			node = node.children[childIndex]
+		}
+		node.add(entry)
+	}
+
+	for depth := 7; depth >= 0 && leaves > size; depth-- {
+		level := levels[depth]
+		sort.SliceStable(level, func(i, j int) bool {
+			return level[i].subtreeCount() < level[j].subtreeCount()
+		})
+		for _, node := range level {
+			if leaves <= size {
+				break
+			}
+			if excess := leaves - size; node.childCount()-1 > excess {
+				// NOTE Merging all the children would leave too few colors.
+				node.mergeSmallest(excess + 1)
+				leaves -= excess
+			} else {
+				leaves -= node.merge() - 1
+			}
+		}
+	}
+
+	model := make(ColorModel, 0, leaves)
+	root.collect(&model)
+	return model
+}
+
+// KMeans is a Quantizer that refines the palette of another Quantizer by
+// repeatedly moving each palette color to the average of the image colors
+// that are closest to it.
+type KMeans struct {
+	// Initial creates the starting palette. If it is nil, MedianCut is used.
+	Initial Quantizer
+	// Iterations is the maximum number of refinements. If it is 0, 10
+	// refinements are made.
+	Iterations int
+}
+
+// Quantize creates a model with k-means refinement.
+func (q KMeans) Quantize(m image.Image, size int) ColorModel {
+	initial := q.Initial
+	if initial == nil {
+		initial = MedianCut{}
+	}
+	iterations := q.Iterations
+	if iterations == 0 {
+		iterations = 10
+	}
+
+	histogram := colorHistogram(m)
+	model := initial.Quantize(m, size)
+	centers := make([][4]float64, len(model))
+	for i, c := range model {
+		key := colorKey(c)
+		centers[i] = [4]float64{float64(key[0]), float64(key[1]), float64(key[2]), float64(key[3])}
+	}
+
+	for iteration := 0; iteration < iterations; iteration++ {
+		sums := make([][4]float64, len(centers))
+		counts := make([]int, len(centers))
+		for _, entry := range histogram {
+			nearest := nearestCenter(centers, entry.c)
+			for c := range sums[nearest] {
+				sums[nearest][c] += float64(entry.c[c]) * float64(entry.n)
+			}
+			counts[nearest] += entry.n
+		}
+
+		changed := false
+		for i := range centers {
+			if counts[i] == 0 {
+				continue
+			}
+			for c := range centers[i] {
+				mean := sums[i][c] / float64(counts[i])
+				if mean != centers[i][c] {
+					centers[i][c] = mean
+					changed = true
+				}
+			}
+		}
+		if !changed {
+			break
+		}
+	}
+
+	for i, center := range centers {
+		model[i] = roundedColor(center)
+	}
+	return model
+}
+
+// ColorCount is a color and the number of pixels that have it.
+type colorCount struct {
+	c [4]byte
+	n int
+}
+
+// ColorHistogram counts the pixels of each distinct color in the image.
+func colorHistogram(m image.Image) []colorCount {
+	counts := make(map[[4]byte]int)
+	bounds := m.Bounds()
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			counts[colorKey(m.At(x, y))]++
+		}
+	}
+	histogram := make([]colorCount, 0, len(counts))
+	for c, n := range counts {
+		histogram = append(histogram, colorCount{c, n})
+	}
+	// NOTE Sort so that the results don't depend on the map order.
+	sort.Slice(histogram, func(i, j int) bool {
+		return bytes.Compare(histogram[i].c[:], histogram[j].c[:]) < 0
+	})
+	return histogram
+}
+
+// ColorBox is a group of colors used by median cut.
+type colorBox []colorCount
+
+// WidestChannel returns the channel with the largest range of values, and its
+// range.
+func (box colorBox) widestChannel() (channel int, width int) {
+	for c := 0; c < 4; c++ {
+		min, max := 0xFF, 0
+		for _, entry := range box {
+			v := int(entry.c[c])
+			if v < min {
+				min = v
+			}
+			if v > max {
+				max = v
+			}
+		}
+		if max-min > width {
+			channel, width = c, max-min
+		}
+	}
+	return
+}
+
+// Split sorts the box along its widest channel and splits it at the median
+// pixel.
+func (box colorBox) split() (low, high colorBox) {
+	channel, _ := box.widestChannel()
+	sort.SliceStable(box, func(i, j int) bool {
+		return box[i].c[channel] < box[j].c[channel]
+	})
+	total := 0
+	for _, entry := range box {
+		total += entry.n
+	}
+	median := 1
+	for count := box[0].n; median < len(box)-1 && count*2 < total; median++ {
+		count += box[median].n
+	}
+	return box[:median], box[median:]
+}
+
+// Average returns the average color of the box, weighted by pixel count.
+func (box colorBox) average() color.Color {
+	var sums [4]float64
+	total := 0
+	for _, entry := range box {
+		for c := range sums {
+			sums[c] += float64(entry.c[c]) * float64(entry.n)
+		}
+		total += entry.n
+	}
+	for c := range sums {
+		sums[c] /= float64(total)
+	}
+	return roundedColor(sums)
+}
+
+// OctreeNode is a node of the octree. Because alpha is one of the channels,
+// each node can have 16 children.
+type octreeNode struct {
+	children *[16]*octreeNode
+	sums     [4]float64
+	n        int
+}
+
+// OctreeChildIndex picks the child of a node at the depth, using the bit of
+// each channel at the depth.
+func octreeChildIndex(c [4]byte, depth int) int {
+	shift := 7 - depth
+	index := 0
+	for channel, v := range c {
+		index |= int((v>>shift)&1) << channel
+	}
+	return index
+}
+
+// Add adds the pixels of a color to the node.
+func (node *octreeNode) add(entry colorCount) {
+	for c := range node.sums {
+		node.sums[c] += float64(entry.c[c]) * float64(entry.n)
+	}
+	node.n += entry.n
+}
+
+// SubtreeCount returns the number of pixels in the node and its descendants.
+func (node *octreeNode) subtreeCount() int {
+	n := node.n
+	if node.children != nil {
+		for _, child := range node.children {
+			if child != nil {
+				n += child.subtreeCount()
+			}
+		}
+	}
+	return n
+}
+
+// Merge makes the node a leaf by merging its children into it, and returns the
+// number of children that were merged.
+func (node *octreeNode) merge() (merged int) {
+	if node.children == nil {
+		return 1
+	}
+	for _, child := range node.children {
+		if child == nil {
+			continue
+		}
+		child.merge()
+		for c := range node.sums {
+			node.sums[c] += child.sums[c]
+		}
+		node.n += child.n
+		merged++
+	}
+	node.children = nil
+	return
+}
+
+// ChildCount returns the number of children of the node.
+func (node *octreeNode) childCount() (count int) {
+	if node.children != nil {
+		for _, child := range node.children {
+			if child != nil {
+				count++
+			}
+		}
+	}
+	return
+}
+
+// MergeSmallest merges the n children with the fewest pixels into one child.
+// The children must be leaves.
+func (node *octreeNode) mergeSmallest(n int) {
+	var children []int
+	for i, child := range node.children {
+		if child != nil {
+			children = append(children, i)
+		}
+	}
+	sort.SliceStable(children, func(i, j int) bool {
+		return node.children[children[i]].n < node.children[children[j]].n
+	})
+	into := node.children[children[0]]
+	for _, i := range children[1:n] {
+		child := node.children[i]
+		for c := range into.sums {
+			into.sums[c] += child.sums[c]
+		}
+		into.n += child.n
+		node.children[i] = nil
+	}
+}
+
+// Collect appends the average colors of the leaves to the model.
+func (node *octreeNode) collect(model *ColorModel) {
+	if node.children == nil {
+		if node.n > 0 {
+			var mean [4]float64
+			for c := range mean {
+				mean[c] = node.sums[c] / float64(node.n)
+			}
+			*model = append(*model, roundedColor(mean))
+		}
+		return
+	}
+	for _, child := range node.children {
+		if child != nil {
+			child.collect(model)
+		}
+	}
+}
+
+// NearestCenter returns the index of the center closest to the color.
+func nearestCenter(centers [][4]float64, c [4]byte) int {
+	nearest := 0
+	best := -1.0
+	for i, center := range centers {
+		var d float64
+		for channel := range center {
+			diff := center[channel] - float64(c[channel])
+			d += diff * diff
+		}
+		if best < 0 || d < best {
+			nearest, best = i, d
+		}
+	}
+	return nearest
+}
+
+// RoundedColor converts averaged channels to a color.
+func roundedColor(channels [4]float64) color.Color {
+	var c [4]byte
+	for i, v := range channels {
+		c[i] = byte(v + 0.5)
+	}
+	// NOTE Channels of alpha-premultiplied colors cannot be larger than
+	// alpha.
+	for i := 0; i < 3; i++ {
+		if c[i] > c[3] {
+			c[i] = c[3]
+		}
+	}
+	return color.RGBA{c[0], c[1], c[2], c[3]}
+}
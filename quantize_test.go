@@ -0,0 +1,131 @@
+package imretro
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"testing"
+)
+
+// Quantizers are the quantizers that are tested.
+var quantizers = map[string]Quantizer{
+	"median cut": MedianCut{},
+	"octree":     Octree{},
+	"k-means":    KMeans{},
+}
+
+// ClusteredImage makes an image with small variations of a few colors.
+func clusteredImage(centers []color.RGBA) *image.RGBA {
+	m := image.NewRGBA(image.Rect(0, 0, 8, 8*len(centers)))
+	for i, center := range centers {
+		for y := 0; y < 8; y++ {
+			for x := 0; x < 8; x++ {
+				offset := uint8((x + y) % 4)
+				c := center
+				c.R = c.R - c.R/0x40 + offset
+				c.G = c.G - c.G/0x40 + offset
+				c.B = c.B - c.B/0x40 + offset
+				m.SetRGBA(x, i*8+y, c)
+			}
+		}
+	}
+	return m
+}
+
+// TestQuantizeClusters tests that a palette color is made near each cluster of
+// colors.
+func TestQuantizeClusters(t *testing.T) {
+	centers := []color.RGBA{
+		{0xC0, 0x10, 0x10, 0xFF},
+		{0x10, 0xC0, 0x10, 0xFF},
+		{0x10, 0x10, 0xC0, 0xFF},
+		{0xE0, 0xE0, 0xE0, 0xFF},
+	}
+	m := clusteredImage(centers)
+
+	for name, q := range quantizers {
+		t.Logf(`Testing %s`, name)
+		model := q.Quantize(m, 4)
+		if l := len(model); l != 4 {
+			t.Fatalf(`len(model) = %d, want 4`, l)
+		}
+		for _, center := range centers {
+			nearest := model[model.NearestIndex(center, nil)]
+			if d := EuclideanDistance(center, nearest); d > 0.05 {
+				t.Errorf(`closest color to %v is %v (distance %v)`, center, nearest, d)
+			}
+		}
+	}
+}
+
+// TestQuantizeFewColors tests that an image with fewer colors than the size
+// gets a palette of exactly its colors.
+func TestQuantizeFewColors(t *testing.T) {
+	red := color.RGBA{0xFF, 0, 0, 0xFF}
+	m := image.NewRGBA(image.Rect(0, 0, 3, 1))
+	m.Set(0, 0, red)
+	m.Set(1, 0, white)
+	m.Set(2, 0, red)
+
+	for name, q := range quantizers {
+		t.Logf(`Testing %s`, name)
+		model := q.Quantize(m, 256)
+		if l := len(model); l != 2 {
+			t.Fatalf(`len(model) = %d, want 2`, l)
+		}
+		CompareColors(t, model[model.NearestIndex(red, nil)], red)
+		CompareColors(t, model[model.NearestIndex(white, nil)], white)
+	}
+}
+
+// TestAdaptiveModelUnsupportedMode tests that a model cannot be made for an
+// unsupported pixel mode.
+func TestAdaptiveModelUnsupportedMode(t *testing.T) {
+	m := image.NewRGBA(image.Rect(0, 0, 1, 1))
+	want := UnsupportedBitModeError(0b1100_0000)
+	if _, err := AdaptiveModel(m, 0b1100_0000, MedianCut{}); err != want {
+		t.Fatalf(`err = %v, want %v`, err, want)
+	}
+}
+
+// TestEncodeAdaptivePalette tests that the encoder can make a palette for the
+// image.
+func TestEncodeAdaptivePalette(t *testing.T) {
+	red := color.RGBA{0xC0, 0x10, 0x10, 0xFF}
+	blue := color.RGBA{0x10, 0x10, 0xC0, 0xFF}
+	m := clusteredImage([]color.RGBA{red, blue})
+
+	var b bytes.Buffer
+	o := EncodeOptions{PixelMode: OneBit, AdaptivePalette: Octree{}}
+	if err := EncodeWithOptions(&b, m, o); err != nil {
+		t.Fatalf(`err = %v, want nil`, err)
+	}
+	decoded, err := Decode(&b, nil)
+	if err != nil {
+		t.Fatalf(`err = %v, want nil`, err)
+	}
+	for _, p := range []image.Point{{0, 0}, {7, 7}, {0, 8}, {7, 15}} {
+		if d := EuclideanDistance(decoded.At(p.X, p.Y), m.At(p.X, p.Y)); d > 0.05 {
+			t.Errorf(`color at %v = %v, want about %v`, p, decoded.At(p.X, p.Y), m.At(p.X, p.Y))
+		}
+	}
+}
+
+// TestQuantizeSize tests that an image with many colors gets a palette of
+// exactly the requested size.
+func TestQuantizeSize(t *testing.T) {
+	m := image.NewRGBA(image.Rect(0, 0, 64, 64))
+	for y := 0; y < 64; y++ {
+		for x := 0; x < 64; x++ {
+			m.Set(x, y, color.RGBA{uint8(x * 4), uint8(y * 4), uint8((x ^ y) * 4), 0xFF})
+		}
+	}
+
+	for name, q := range quantizers {
+		for _, size := range []int{2, 4, 256} {
+			if l := len(q.Quantize(m, size)); l != size {
+				t.Errorf(`%s: len(model) = %d, want %d`, name, l, size)
+			}
+		}
+	}
+}
@@ -0,0 +1,247 @@
+package imretro
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	"io"
+
+	"github.com/spenserblack/go-bitio"
+)
+
+// TilemapSignature is the "magic string" used for identifying a tilemap file.
+const TilemapSignature = "IMRTMAP"
+
+// Mode flags for the tilemap format.
+const (
+	// WideTileIndices signifies that each tile index uses 2 bytes instead of
+	// 1 byte.
+	wideTileIndices byte = 1 << 7
+	// WithTileFlips signifies that the flips of each tile are stored after the
+	// tile indices.
+	withTileFlips byte = 1 << 6
+	// TilemapModeBits are the bits of the mode byte that are used. The other
+	// bits are reserved for future flags.
+	tilemapModeBits = wideTileIndices | withTileFlips
+)
+
+// TileFlip is the type for flipping a tile when it is rendered.
+type TileFlip = byte
+
+// Flags for flipping tiles.
+const (
+	FlipHorizontal TileFlip = 1 << iota
+	FlipVertical
+)
+
+// MapTile is a cell of a Tilemap.
+type MapTile struct {
+	// Index is the number of the tile in the tileset.
+	Index uint16
+	// Flip is a union of FlipHorizontal and FlipVertical.
+	Flip TileFlip
+}
+
+// Tilemap is a grid of tiles from a tileset.
+type Tilemap struct {
+	// Width and Height are the number of columns and rows of tiles.
+	Width, Height int
+	// Tiles are the cells of the grid, from left to right, then top to
+	// bottom.
+	Tiles []MapTile
+}
+
+// ErrMismatchedTiles is returned when the number of tiles of a tilemap does
+// not match its width and height.
+var ErrMismatchedTiles = errors.New("Tile count does not match the tilemap size")
+
+// MissingTileError is returned when a tilemap uses a tile that is not in the
+// tileset.
+type MissingTileError uint16
+
+// Error reports the missing tile.
+func (e MissingTileError) Error() string {
+	return fmt.Sprintf("Tile %d is not in the tileset", uint16(e))
+}
+
+// NewTilemap creates a tilemap where every cell is the first tile.
+func NewTilemap(width, height int) *Tilemap {
+	return &Tilemap{width, height, make([]MapTile, width*height)}
+}
+
+// At returns the tile at the column and row.
+func (m *Tilemap) At(col, row int) MapTile {
+	return m.Tiles[row*m.Width+col]
+}
+
+// Set sets the tile at the column and row.
+func (m *Tilemap) Set(col, row int, tile MapTile) {
+	m.Tiles[row*m.Width+col] = tile
+}
+
+// Render draws the tilemap with the tileset. The returned image uses the
+// palette of the tileset, and its bounds start at (0, 0).
+func (m *Tilemap) Render(t Tileset) (MutableImage, error) {
+	if err := m.checkSize(); err != nil {
+		return nil, err
+	}
+	tiles := make([]Image, t.Len())
+	for i := range tiles {
+		tiles[i] = t.Tile(i)
+	}
+	dst := New(image.Rect(0, 0, m.Width*t.TileWidth, m.Height*t.TileHeight), ColorModel(t.Image.Palette()))
+	for row := 0; row < m.Height; row++ {
+		for col := 0; col < m.Width; col++ {
+			cell := m.At(col, row)
+			if int(cell.Index) >= len(tiles) {
+				return nil, MissingTileError(cell.Index)
+			}
+			tile := tiles[cell.Index]
+			src := tile.Bounds()
+			for y := 0; y < t.TileHeight; y++ {
+				for x := 0; x < t.TileWidth; x++ {
+					sx, sy := x, y
+					if cell.Flip&FlipHorizontal != 0 {
+						sx = t.TileWidth - 1 - x
+					}
+					if cell.Flip&FlipVertical != 0 {
+						sy = t.TileHeight - 1 - y
+					}
+					index := tile.ColorIndexAt(src.Min.X+sx, src.Min.Y+sy)
+					dst.SetColorIndex(col*t.TileWidth+x, row*t.TileHeight+y, index)
+				}
+			}
+		}
+	}
+	return dst, nil
+}
+
+// CheckSize returns ErrMismatchedTiles if the tilemap does not have a tile for
+// each cell.
+func (m *Tilemap) checkSize() error {
+	if m.Width < 0 || m.Height < 0 || len(m.Tiles) != m.Width*m.Height {
+		return ErrMismatchedTiles
+	}
+	return nil
+}
+
+// EncodeTilemap writes the tilemap to w. Tile indices use 1 byte each unless
+// an index is larger than 255, and tile flips are only written if a tile is
+// flipped.
+func EncodeTilemap(w io.Writer, m *Tilemap) error {
+	if err := m.checkSize(); err != nil {
+		return err
+	}
+	var mode byte
+	for _, tile := range m.Tiles {
+		if tile.Index > 0xFF {
+			mode |= wideTileIndices
+		}
+		if tile.Flip != 0 {
+			mode |= withTileFlips
+		}
+	}
+
+	if _, err := w.Write([]byte(TilemapSignature)); err != nil {
+		return err
+	}
+	if _, err := w.Write([]byte{mode}); err != nil {
+		return err
+	}
+	if err := encodeDimensions(w, m.Width, m.Height); err != nil {
+		return err
+	}
+
+	indexSize := 1
+	if mode&wideTileIndices != 0 {
+		indexSize = 2
+	}
+	indices := make([]byte, 0, len(m.Tiles)*indexSize)
+	for _, tile := range m.Tiles {
+		if indexSize == 2 {
+			indices = append(indices, byte(tile.Index>>8))
+		}
+		indices = append(indices, byte(tile.Index))
+	}
+	if _, err := w.Write(indices); err != nil {
+		return err
+	}
+
+	if mode&withTileFlips == 0 {
+		return nil
+	}
+	flips := bitio.NewWriter(w, 1)
+	for _, tile := range m.Tiles {
+		if _, err := flips.WriteBits(bitio.Bits(tile.Flip&0b11), 2); err != nil {
+			return err
+		}
+	}
+	_, err := flips.CommitPending()
+	return err
+}
+
+// DecodeTilemap reads a tilemap written by EncodeTilemap. Unknown bits of the
+// mode byte are rejected, so that they can be used by future versions of the
+// format.
+func DecodeTilemap(r io.Reader) (*Tilemap, error) {
+	reader := &offsetReader{Reader: r}
+	buff := make([]byte, len(TilemapSignature)+1)
+	mode, err := checkSignature(reader, buff, TilemapSignature)
+	if err != nil {
+		return nil, err
+	}
+	if mode&^tilemapModeBits != 0 {
+		return nil, newDecodeError(PhaseMode, int64(len(TilemapSignature)), InvalidModeError(mode))
+	}
+	width, height, err := decodeDimensions(reader)
+	if err != nil {
+		return nil, newDecodeError(PhaseDimensions, reader.offset, err)
+	}
+	count := width * height
+
+	indexSize := 1
+	if mode&wideTileIndices != 0 {
+		indexSize = 2
+	}
+	// NOTE The data is read before the tiles are created, so that a large
+	// size in a short file does not allocate the tiles.
+	indices, err := readData(reader, count*indexSize)
+	if err != nil {
+		return nil, err
+	}
+	var flips []byte
+	if mode&withTileFlips != 0 {
+		if flips, err = readData(reader, (count*2+7)/8); err != nil {
+			return nil, err
+		}
+	}
+
+	m := NewTilemap(width, height)
+	for i := range m.Tiles {
+		if indexSize == 2 {
+			m.Tiles[i].Index = binary.BigEndian.Uint16(indices[i*2:])
+		} else {
+			m.Tiles[i].Index = uint16(indices[i])
+		}
+		if flips != nil {
+			offset := i * 2
+			m.Tiles[i].Flip = (flips[offset/8] >> (6 - offset%8)) & 0b11
+		}
+	}
+	return m, nil
+}
+
+// ReadData reads size bytes of data. The buffer grows with the bytes that are
+// read, instead of being allocated for the size before reading.
+func readData(r *offsetReader, size int) ([]byte, error) {
+	var b bytes.Buffer
+	if _, err := b.ReadFrom(io.LimitReader(r, int64(size))); err != nil {
+		return nil, newDecodeError(PhaseData, r.offset, err)
+	}
+	if b.Len() < size {
+		return nil, newDecodeError(PhaseData, r.offset, io.ErrUnexpectedEOF)
+	}
+	return b.Bytes(), nil
+}
@@ -0,0 +1,183 @@
+package imretro
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"io"
+	"testing"
+)
+
+// TestEncodeTilemap tests that a tilemap is encoded with 1-byte indices and no
+// flips when they are not needed.
+func TestEncodeTilemap(t *testing.T) {
+	m := NewTilemap(3, 1)
+	m.Set(1, 0, MapTile{Index: 5})
+	m.Set(2, 0, MapTile{Index: 0xFF})
+
+	var b bytes.Buffer
+	if err := EncodeTilemap(&b, m); err != nil {
+		t.Fatalf(`err = %v, want nil`, err)
+	}
+	want := []byte{
+		'I', 'M', 'R', 'T', 'M', 'A', 'P',
+		0,
+		0x00, 0x30, 0x01,
+		0, 5, 0xFF,
+	}
+	if actual := b.Bytes(); !bytes.Equal(actual, want) {
+		t.Fatalf(`bytes = %v, want %v`, actual, want)
+	}
+}
+
+// TestEncodeTilemapWideFlipped tests that large indices and flips are
+// encoded.
+func TestEncodeTilemapWideFlipped(t *testing.T) {
+	m := NewTilemap(1, 3)
+	m.Set(0, 0, MapTile{Index: 0x1234, Flip: FlipHorizontal})
+	m.Set(0, 2, MapTile{Index: 1, Flip: FlipHorizontal | FlipVertical})
+
+	var b bytes.Buffer
+	if err := EncodeTilemap(&b, m); err != nil {
+		t.Fatalf(`err = %v, want nil`, err)
+	}
+	want := []byte{
+		'I', 'M', 'R', 'T', 'M', 'A', 'P',
+		wideTileIndices | withTileFlips,
+		0x00, 0x10, 0x03,
+		0x12, 0x34, 0, 0, 0, 1,
+		0b01_00_11_00,
+	}
+	if actual := b.Bytes(); !bytes.Equal(actual, want) {
+		t.Fatalf(`bytes = %v, want %v`, actual, want)
+	}
+
+	decoded, err := DecodeTilemap(&b)
+	if err != nil {
+		t.Fatalf(`err = %v, want nil`, err)
+	}
+	if decoded.Width != 1 || decoded.Height != 3 {
+		t.Fatalf(`size = %dx%d, want 1x3`, decoded.Width, decoded.Height)
+	}
+	for i, tile := range m.Tiles {
+		if actual := decoded.Tiles[i]; actual != tile {
+			t.Errorf(`tile %d = %v, want %v`, i, actual, tile)
+		}
+	}
+}
+
+// TestDecodeTilemapErrors tests that invalid tilemaps cannot be decoded.
+func TestDecodeTilemapErrors(t *testing.T) {
+	tests := [][]byte{
+		[]byte("IMRETRO\x00\x00\x10\x01\x00"),
+		[]byte("IMRTMAP\x00\x00\x20\x01\x00"),
+		[]byte("IMRTMAP\x40\x00\x10\x01\x00"),
+	}
+	for i, data := range tests {
+		if _, err := DecodeTilemap(bytes.NewBuffer(data)); err == nil {
+			t.Errorf(`test %d: err = nil`, i)
+		}
+	}
+}
+
+// TestDecodeTilemapTruncated tests that the phase of a truncated tilemap is
+// reported, and that the end of the data is an unexpected EOF.
+func TestDecodeTilemapTruncated(t *testing.T) {
+	tests := []struct {
+		data  string
+		phase DecodePhase
+	}{
+		{"IMRTMAP\x00\x00", PhaseDimensions},
+		{"IMRTMAP\x00\x00\x20\x01\x00", PhaseData},
+		{"IMRTMAP\x80\xFF\xFF\xFF\x00\x01\x00", PhaseData},
+	}
+	for i, tt := range tests {
+		_, err := DecodeTilemap(bytes.NewBufferString(tt.data))
+		var decodeErr *DecodeError
+		if !errors.As(err, &decodeErr) || decodeErr.Phase != tt.phase {
+			t.Errorf(`test %d: err = %v, want %v error`, i, err, tt.phase)
+		}
+		if !errors.Is(err, io.ErrUnexpectedEOF) {
+			t.Errorf(`test %d: err = %v, want %v`, i, err, io.ErrUnexpectedEOF)
+		}
+	}
+}
+
+// TestDecodeTilemapReservedBits tests that a mode byte with unknown bits is
+// rejected.
+func TestDecodeTilemapReservedBits(t *testing.T) {
+	for _, mode := range []byte{0b0010_0000, 0b0000_0001} {
+		data := append([]byte(TilemapSignature), mode, 0x00, 0x10, 0x01, 0x00)
+		_, err := DecodeTilemap(bytes.NewBuffer(data))
+		var decodeErr *DecodeError
+		if !errors.As(err, &decodeErr) || decodeErr.Phase != PhaseMode {
+			t.Fatalf(`mode %08b: err = %v, want mode error`, mode, err)
+		}
+		if want := InvalidModeError(mode); decodeErr.Err != want {
+			t.Errorf(`mode %08b: err = %v, want %v`, mode, decodeErr.Err, want)
+		}
+	}
+}
+
+// TestRenderTilemap tests that a tilemap is rendered with flipped tiles.
+func TestRenderTilemap(t *testing.T) {
+	sheet := New(image.Rect(0, 0, 4, 2), Default2BitColorModel)
+	// NOTE Tile 0 is solid, tile 1 has a different index in each corner.
+	for i := 0; i < 4; i++ {
+		sheet.SetColorIndex(i%2, i/2, 3)
+		sheet.SetColorIndex(2+i%2, i/2, uint8(i))
+	}
+	tileset := Tileset{Image: sheet, TileWidth: 2, TileHeight: 2}
+
+	m := NewTilemap(2, 2)
+	m.Set(1, 0, MapTile{Index: 1})
+	m.Set(0, 1, MapTile{Index: 1, Flip: FlipHorizontal})
+	m.Set(1, 1, MapTile{Index: 1, Flip: FlipHorizontal | FlipVertical})
+
+	rendered, err := m.Render(tileset)
+	if err != nil {
+		t.Fatalf(`err = %v, want nil`, err)
+	}
+	want := [][]uint8{
+		{3, 3, 0, 1},
+		{3, 3, 2, 3},
+		{1, 0, 3, 2},
+		{3, 2, 1, 0},
+	}
+	for y, row := range want {
+		for x, index := range row {
+			if actual := rendered.ColorIndexAt(x, y); actual != index {
+				t.Errorf(`index at (%d, %d) = %d, want %d`, x, y, actual, index)
+			}
+		}
+	}
+
+	m.Set(0, 0, MapTile{Index: 2})
+	if _, err := m.Render(tileset); err != MissingTileError(2) {
+		t.Errorf(`err = %v, want %v`, err, MissingTileError(2))
+	}
+}
+
+// TestMissingTileError tests the error message for a missing tile.
+func TestMissingTileError(t *testing.T) {
+	if actual, want := MissingTileError(7).Error(), "Tile 7 is not in the tileset"; actual != want {
+		t.Fatalf(`Error() = %q, want %q`, actual, want)
+	}
+}
+
+// TestTilemapMismatchedTiles tests that a tilemap without a tile for each cell
+// is not encoded or rendered.
+func TestTilemapMismatchedTiles(t *testing.T) {
+	m := &Tilemap{Width: 2, Height: 2, Tiles: make([]MapTile, 1)}
+	var b bytes.Buffer
+	if err := EncodeTilemap(&b, m); err != ErrMismatchedTiles {
+		t.Errorf(`err = %v, want %v`, err, ErrMismatchedTiles)
+	}
+	if b.Len() != 0 {
+		t.Errorf(`%d bytes written, want 0`, b.Len())
+	}
+	tileset := Tileset{Image: New(image.Rect(0, 0, 1, 1), Default1BitColorModel), TileWidth: 1, TileHeight: 1}
+	if _, err := m.Render(tileset); err != ErrMismatchedTiles {
+		t.Errorf(`err = %v, want %v`, err, ErrMismatchedTiles)
+	}
+}
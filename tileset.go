@@ -0,0 +1,155 @@
+package imretro
+
+import (
+	"errors"
+	"image"
+	"image/color"
+)
+
+// ErrTileMismatch is returned when tiles that should be packed together have
+// different sizes or palettes.
+var ErrTileMismatch = errors.New("Tiles have different sizes or palettes")
+
+// Tileset splits a sheet image into tiles of the same size. Tiles are
+// numbered from left to right, then top to bottom.
+type Tileset struct {
+	// Image is the sheet that contains the tiles.
+	Image Image
+	// TileWidth and TileHeight are the size of each tile.
+	TileWidth, TileHeight int
+	// Margin is the number of pixels between the edges of the sheet and the
+	// tiles.
+	Margin int
+	// Spacing is the number of pixels between adjacent tiles.
+	Spacing int
+}
+
+// Columns returns the number of tiles in each row of the sheet.
+func (t Tileset) Columns() int {
+	return tileCount(t.Image.Bounds().Dx(), t.TileWidth, t.Margin, t.Spacing)
+}
+
+// Rows returns the number of rows of tiles in the sheet.
+func (t Tileset) Rows() int {
+	return tileCount(t.Image.Bounds().Dy(), t.TileHeight, t.Margin, t.Spacing)
+}
+
+// Len returns the number of tiles in the sheet.
+func (t Tileset) Len() int {
+	return t.Columns() * t.Rows()
+}
+
+// Tile returns the nth tile, or nil if there is no nth tile. The tile shares
+// its pixels and palette with the sheet, and its bounds are the area of the
+// sheet that it covers.
+func (t Tileset) Tile(n int) Image {
+	columns := t.Columns()
+	if n < 0 || columns == 0 {
+		return nil
+	}
+	return t.TileAt(n%columns, n/columns)
+}
+
+// TileAt returns the tile at the column and row, or nil if there is no tile
+// there.
+func (t Tileset) TileAt(col, row int) Image {
+	if col < 0 || row < 0 || col >= t.Columns() || row >= t.Rows() {
+		return nil
+	}
+	return subImage(t.Image, t.tileBounds(col, row))
+}
+
+// TileBounds returns the area of the sheet that the tile at the column and row
+// covers.
+func (t Tileset) tileBounds(col, row int) image.Rectangle {
+	min := t.Image.Bounds().Min.Add(image.Pt(
+		t.Margin+col*(t.TileWidth+t.Spacing),
+		t.Margin+row*(t.TileHeight+t.Spacing),
+	))
+	return image.Rectangle{Min: min, Max: min.Add(image.Pt(t.TileWidth, t.TileHeight))}
+}
+
+// PackTileset copies tiles into a new sheet with the given number of columns,
+// margin, and spacing. All tiles must have the same size and palette, and the
+// sheet uses the palette of the tiles. The unused areas of the sheet use the
+// first color of the palette.
+func PackTileset(tiles []Image, columns, margin, spacing int) (Tileset, error) {
+	if len(tiles) == 0 || columns <= 0 {
+		return Tileset{}, ErrTileMismatch
+	}
+	first := tiles[0].Bounds()
+	palette := tiles[0].Palette()
+	for _, tile := range tiles[1:] {
+		if tile.Bounds().Size() != first.Size() || !samePalette(tile.Palette(), palette) {
+			return Tileset{}, ErrTileMismatch
+		}
+	}
+
+	rows := (len(tiles) + columns - 1) / columns
+	width := 2*margin + columns*first.Dx() + (columns-1)*spacing
+	height := 2*margin + rows*first.Dy() + (rows-1)*spacing
+	tileset := Tileset{
+		Image:      New(image.Rect(0, 0, width, height), ColorModel(palette)),
+		TileWidth:  first.Dx(),
+		TileHeight: first.Dy(),
+		Margin:     margin,
+		Spacing:    spacing,
+	}
+	sheet := tileset.Image.(MutableImage)
+	for n, tile := range tiles {
+		dst := tileset.tileBounds(n%columns, n/columns)
+		src := tile.Bounds()
+		for y := 0; y < src.Dy(); y++ {
+			for x := 0; x < src.Dx(); x++ {
+				index := tile.ColorIndexAt(src.Min.X+x, src.Min.Y+y)
+				sheet.SetColorIndex(dst.Min.X+x, dst.Min.Y+y, index)
+			}
+		}
+	}
+	return tileset, nil
+}
+
+// TileCount returns the number of tiles that fit in a length of the sheet.
+func tileCount(length, tileLength, margin, spacing int) int {
+	if tileLength <= 0 {
+		return 0
+	}
+	count := (length - 2*margin + spacing) / (tileLength + spacing)
+	if count < 0 {
+		return 0
+	}
+	return count
+}
+
+// SubImage returns the part of the image in r, sharing the image's pixels if
+// possible. Otherwise, the pixels are copied.
+func subImage(m Image, r image.Rectangle) Image {
+	if subImager, ok := m.(interface {
+		SubImage(image.Rectangle) image.Image
+	}); ok {
+		if sub, ok := subImager.SubImage(r).(Image); ok {
+			return sub
+		}
+	}
+	r = r.Intersect(m.Bounds())
+	sub := New(r, ColorModel(m.Palette()))
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		for x := r.Min.X; x < r.Max.X; x++ {
+			sub.SetColorIndex(x, y, m.ColorIndexAt(x, y))
+		}
+	}
+	return sub
+}
+
+// SamePalette checks if two palettes have the same colors.
+func samePalette(p1, p2 color.Palette) bool {
+	if len(p1) != len(p2) {
+		return false
+	}
+	for i := range p1 {
+		if colorKey(p1[i]) != colorKey(p2[i]) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,113 @@
+package imretro
+
+import (
+	"bytes"
+	"image"
+	"testing"
+)
+
+// TestTileset tests that a sheet is split into tiles, skipping the margin and
+// spacing.
+func TestTileset(t *testing.T) {
+	// NOTE A 3x2 sheet of 2x2 tiles, with a margin of 1 and spacing of 1
+	sheet := New(image.Rect(0, 0, 10, 7), make(ColorModel, 256))
+	tileset := Tileset{Image: sheet, TileWidth: 2, TileHeight: 2, Margin: 1, Spacing: 1}
+	for n := 0; n < 6; n++ {
+		col, row := n%3, n/3
+		x, y := 1+col*3, 1+row*3
+		for i := 0; i < 4; i++ {
+			sheet.SetColorIndex(x+i%2, y+i/2, uint8(n*4+i))
+		}
+	}
+
+	if columns := tileset.Columns(); columns != 3 {
+		t.Errorf(`columns = %d, want 3`, columns)
+	}
+	if rows := tileset.Rows(); rows != 2 {
+		t.Errorf(`rows = %d, want 2`, rows)
+	}
+	if l := tileset.Len(); l != 6 {
+		t.Fatalf(`len = %d, want 6`, l)
+	}
+
+	for n := 0; n < 6; n++ {
+		tile := tileset.Tile(n)
+		if other := tileset.TileAt(n%3, n/3); other.Bounds() != tile.Bounds() {
+			t.Errorf(`tile %d bounds = %v, want %v`, n, other.Bounds(), tile.Bounds())
+		}
+		bounds := tile.Bounds()
+		if size := bounds.Size(); size != image.Pt(2, 2) {
+			t.Fatalf(`tile %d size = %v, want (2,2)`, n, size)
+		}
+		for i := 0; i < 4; i++ {
+			x, y := bounds.Min.X+i%2, bounds.Min.Y+i/2
+			if index, want := tile.ColorIndexAt(x, y), uint8(n*4+i); index != want {
+				t.Errorf(`tile %d index at (%d, %d) = %d, want %d`, n, x, y, index, want)
+			}
+		}
+	}
+
+	for _, n := range []int{-1, 6} {
+		if tile := tileset.Tile(n); tile != nil {
+			t.Errorf(`tile %d = %v, want nil`, n, tile)
+		}
+	}
+	if tile := tileset.TileAt(3, 0); tile != nil {
+		t.Errorf(`tile at (3, 0) = %v, want nil`, tile)
+	}
+}
+
+// TestPackTileset tests that tiles can be packed into a new sheet and encoded.
+func TestPackTileset(t *testing.T) {
+	source := New(image.Rect(0, 0, 6, 2), Default2BitColorModel)
+	for x := 0; x < 6; x++ {
+		source.SetColorIndex(x, 0, uint8(x%4))
+		source.SetColorIndex(x, 1, uint8((x+1)%4))
+	}
+	tiles := Tileset{Image: source, TileWidth: 2, TileHeight: 2}
+	packed, err := PackTileset([]Image{tiles.Tile(2), tiles.Tile(0), tiles.Tile(1)}, 2, 1, 2)
+	if err != nil {
+		t.Fatalf(`err = %v, want nil`, err)
+	}
+
+	if bounds, want := packed.Image.Bounds(), image.Rect(0, 0, 8, 8); bounds != want {
+		t.Fatalf(`bounds = %v, want %v`, bounds, want)
+	}
+	if l := packed.Len(); l != 4 {
+		t.Errorf(`len = %d, want 4`, l)
+	}
+	for n, want := range []int{2, 0, 1} {
+		tile := packed.Tile(n)
+		original := tiles.Tile(want)
+		for y := 0; y < 2; y++ {
+			for x := 0; x < 2; x++ {
+				actual := tile.ColorIndexAt(tile.Bounds().Min.X+x, tile.Bounds().Min.Y+y)
+				expected := original.ColorIndexAt(original.Bounds().Min.X+x, original.Bounds().Min.Y+y)
+				if actual != expected {
+					t.Errorf(`packed tile %d index at (%d, %d) = %d, want %d`, n, x, y, actual, expected)
+				}
+			}
+		}
+	}
+
+	var b bytes.Buffer
+	if err := Encode(&b, packed.Image, TwoBit); err != nil {
+		t.Fatalf(`err = %v, want nil`, err)
+	}
+}
+
+// TestPackTilesetMismatch tests that tiles of different sizes or palettes
+// cannot be packed together.
+func TestPackTilesetMismatch(t *testing.T) {
+	tests := [][]Image{
+		nil,
+		{New(image.Rect(0, 0, 2, 2), Default1BitColorModel), New(image.Rect(0, 0, 2, 3), Default1BitColorModel)},
+		{New(image.Rect(0, 0, 2, 2), Default1BitColorModel), New(image.Rect(0, 0, 2, 2), ColorModel{white, black})},
+	}
+
+	for i, tiles := range tests {
+		if _, err := PackTileset(tiles, 1, 0, 0); err != ErrTileMismatch {
+			t.Errorf(`test %d: err = %v, want %v`, i, err, ErrTileMismatch)
+		}
+	}
+}